@@ -0,0 +1,121 @@
+// Package apperr defines the errors handlers return to describe what went
+// wrong. The server's error handler turns them into the JSON error envelope
+// and the matching HTTP status.
+package apperr
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"time"
+)
+
+// Code identifies the kind of error in API responses.
+type Code string
+
+const (
+	CodeValidation          Code = "validation_error"
+	CodeUnauthorized        Code = "unauthorized"
+	CodeForbidden           Code = "forbidden"
+	CodeNotFound            Code = "not_found"
+	CodeConflict            Code = "conflict"
+	CodeGone                Code = "gone"
+	CodePreconditionFailed  Code = "precondition_failed"
+	CodeRangeNotSatisfiable Code = "range_not_satisfiable"
+	CodeTooManyRequests     Code = "too_many_requests"
+	CodeInternal            Code = "internal_error"
+)
+
+var statuses = map[Code]int{
+	CodeValidation:          http.StatusBadRequest,
+	CodeUnauthorized:        http.StatusUnauthorized,
+	CodeForbidden:           http.StatusForbidden,
+	CodeNotFound:            http.StatusNotFound,
+	CodeConflict:            http.StatusConflict,
+	CodeGone:                http.StatusGone,
+	CodePreconditionFailed:  http.StatusPreconditionFailed,
+	CodeRangeNotSatisfiable: http.StatusRequestedRangeNotSatisfiable,
+	CodeTooManyRequests:     http.StatusTooManyRequests,
+	CodeInternal:            http.StatusInternalServerError,
+}
+
+// Status is the HTTP status code responses with this code are sent with.
+func (c Code) Status() int {
+	if status, ok := statuses[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Error is an error that can be shown to the client. Message and Details are
+// safe to expose; Err is the underlying cause and is only logged.
+type Error struct {
+	Code    Code
+	Message string
+	Details map[string]interface{}
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func Validation(message string) *Error {
+	return New(CodeValidation, message)
+}
+
+func Unauthorized(message string) *Error {
+	return New(CodeUnauthorized, message)
+}
+
+func Forbidden(message string) *Error {
+	return New(CodeForbidden, message)
+}
+
+func NotFound(message string) *Error {
+	return New(CodeNotFound, message)
+}
+
+func Conflict(message string) *Error {
+	return New(CodeConflict, message)
+}
+
+func Gone(message string) *Error {
+	return New(CodeGone, message)
+}
+
+func PreconditionFailed(message string) *Error {
+	return New(CodePreconditionFailed, message)
+}
+
+// TooManyRequests reports that the client has to wait for retryAfter before
+// trying again.
+func TooManyRequests(message string, retryAfter time.Duration) *Error {
+	e := New(CodeTooManyRequests, message)
+	e.Details = map[string]interface{}{"retry_after": int(math.Ceil(retryAfter.Seconds()))}
+	return e
+}
+
+// Internal reports a failure that is not the client's fault. The message is
+// shown to the client; err is logged.
+func Internal(message string, err error) *Error {
+	return &Error{Code: CodeInternal, Message: message, Err: err}
+}
+
+// As returns err as an *Error, if it is or wraps one.
+func As(err error) (*Error, bool) {
+	var e *Error
+	ok := errors.As(err, &e)
+	return e, ok
+}
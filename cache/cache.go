@@ -0,0 +1,28 @@
+// Package cache is a small key/value cache abstraction backed by Redis in
+// production and by process memory in tests and local development.
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrMiss is returned by Get when the key does not exist.
+var ErrMiss = errors.New("cache: miss")
+
+type Cache interface {
+	// Get returns the value stored at key or ErrMiss.
+	Get(ctx context.Context, key string) (string, error)
+	// Set stores value at key. A ttl of zero keeps the key forever.
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	// SetNX stores value at key only if the key does not exist yet, and
+	// reports whether it did.
+	SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	// Incr atomically increments the integer stored at key, starting from 0.
+	Incr(ctx context.Context, key string) (int64, error)
+	// Expire sets the time to live of an existing key.
+	Expire(ctx context.Context, key string, ttl time.Duration) error
+	// Del removes the keys. Missing keys are ignored.
+	Del(ctx context.Context, keys ...string) error
+}
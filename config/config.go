@@ -2,6 +2,9 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"time"
+	"trademarkia/ratelimit"
 
 	_ "github.com/joho/godotenv/autoload"
 )
@@ -20,4 +23,142 @@ var (
 	PG_PASSWORD                  = os.Getenv("PG_PASSWORD")
 	PG_DBNAME                    = os.Getenv("PG_DBNAME")
 	REDIS_ADDR                   = os.Getenv("RE_ADDR")
+
+	// JWT_KEY_DIR holds the PEM keys access tokens are signed with. Without it
+	// an ephemeral key is generated on startup.
+	JWT_KEY_DIR             = getEnv("JWT_KEY_DIR", "")
+	JWT_KEY_RELOAD_INTERVAL = getDuration("JWT_KEY_RELOAD_INTERVAL", time.Minute)
+	JWT_ISSUER              = getEnv("JWT_ISSUER", PUBLIC_URL)
+	JWT_AUDIENCE            = getEnv("JWT_AUDIENCE", "trademarkia")
+
+	// Lifetime of access tokens, and of refresh tokens since they were last
+	// used
+	ACCESS_TOKEN_TTL  = getDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
+	REFRESH_TOKEN_TTL = getDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+
+	// REQUIRE_EMAIL_VERIFICATION refuses logins until the user has verified
+	// their email address
+	REQUIRE_EMAIL_VERIFICATION = getBool("REQUIRE_EMAIL_VERIFICATION", false)
+	EMAIL_VERIFICATION_TTL     = getDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour)
+	PASSWORD_RESET_TTL         = getDuration("PASSWORD_RESET_TTL", time.Hour)
+	// PASSWORD_RESET_URL is the page password reset links point to. It
+	// receives the token in the token query parameter.
+	PASSWORD_RESET_URL = getEnv("PASSWORD_RESET_URL", PUBLIC_URL+"/password/reset")
+
+	// TOTP_ISSUER is the account issuer shown in authenticator apps.
+	// MFA_CHALLENGE_TTL is how long a user has to enter their code after
+	// giving their password.
+	TOTP_ISSUER       = getEnv("TOTP_ISSUER", "Trademarkia")
+	MFA_CHALLENGE_TTL = getDuration("MFA_CHALLENGE_TTL", 5*time.Minute)
+
+	// OpenID Connect sign in is enabled when OIDC_ISSUER_URL is set. The
+	// provider must send users back to OIDC_REDIRECT_URL, and they have
+	// OIDC_LOGIN_TTL to sign in there.
+	OIDC_ISSUER_URL    = getEnv("OIDC_ISSUER_URL", "")
+	OIDC_CLIENT_ID     = getEnv("OIDC_CLIENT_ID", "")
+	OIDC_CLIENT_SECRET = getEnv("OIDC_CLIENT_SECRET", "")
+	OIDC_REDIRECT_URL  = getEnv("OIDC_REDIRECT_URL", PUBLIC_URL+"/auth/oidc/callback")
+	OIDC_SCOPES        = getEnv("OIDC_SCOPES", "email profile")
+	OIDC_LOGIN_TTL     = getDuration("OIDC_LOGIN_TTL", 10*time.Minute)
+
+	// Rate limits, written as count/window; "0" turns one off. Login,
+	// registration and the endpoints that send account emails are limited per
+	// client IP and per account email, public share downloads per client IP,
+	// and protected routes per user in groups named after the API key scopes.
+	RATE_LIMIT_LOGIN_IP         = getRate("RATE_LIMIT_LOGIN_IP", "20/1m")
+	RATE_LIMIT_LOGIN_ACCOUNT    = getRate("RATE_LIMIT_LOGIN_ACCOUNT", "10/1m")
+	RATE_LIMIT_REGISTER_IP      = getRate("RATE_LIMIT_REGISTER_IP", "10/1h")
+	RATE_LIMIT_REGISTER_ACCOUNT = getRate("RATE_LIMIT_REGISTER_ACCOUNT", "5/1h")
+	RATE_LIMIT_READ             = getRate("RATE_LIMIT_READ", "300/1m")
+	RATE_LIMIT_UPLOAD           = getRate("RATE_LIMIT_UPLOAD", "60/1m")
+	RATE_LIMIT_SHARE            = getRate("RATE_LIMIT_SHARE", "60/1m")
+	RATE_LIMIT_DELETE           = getRate("RATE_LIMIT_DELETE", "60/1m")
+	RATE_LIMIT_ACCOUNT          = getRate("RATE_LIMIT_ACCOUNT", "30/1m")
+	RATE_LIMIT_ADMIN            = getRate("RATE_LIMIT_ADMIN", "120/1m")
+	RATE_LIMIT_SHARE_ACCESS     = getRate("RATE_LIMIT_SHARE_ACCESS", "60/1m")
+	RATE_LIMIT_EMAIL_IP         = getRate("RATE_LIMIT_EMAIL_IP", "10/1h")
+	RATE_LIMIT_EMAIL_ACCOUNT    = getRate("RATE_LIMIT_EMAIL_ACCOUNT", "3/1h")
+
+	// After LOGIN_LOCKOUT_THRESHOLD failed logins in a row an account is
+	// locked for LOGIN_LOCKOUT_BASE, doubling with every further failure up
+	// to LOGIN_LOCKOUT_MAX
+	LOGIN_LOCKOUT_THRESHOLD = getInt("LOGIN_LOCKOUT_THRESHOLD", 5)
+	LOGIN_LOCKOUT_BASE      = getDuration("LOGIN_LOCKOUT_BASE", time.Minute)
+	LOGIN_LOCKOUT_MAX       = getDuration("LOGIN_LOCKOUT_MAX", time.Hour)
+
+	// A password protected share is locked like an account after
+	// SHARE_PASSWORD_ATTEMPTS wrong passwords in a row
+	SHARE_PASSWORD_ATTEMPTS = getInt("SHARE_PASSWORD_ATTEMPTS", 5)
+
+	// MAIL_BACKEND is "smtp" or "log". The log backend writes messages to
+	// MAIL_DIR, or to the server log when MAIL_DIR is empty.
+	MAIL_BACKEND  = getEnv("MAIL_BACKEND", "log")
+	MAIL_DIR      = getEnv("MAIL_DIR", "")
+	MAIL_FROM     = getEnv("MAIL_FROM", "noreply@localhost")
+	SMTP_HOST     = getEnv("SMTP_HOST", "localhost")
+	SMTP_PORT     = getInt("SMTP_PORT", 587)
+	SMTP_USERNAME = getEnv("SMTP_USERNAME", "")
+	SMTP_PASSWORD = getEnv("SMTP_PASSWORD", "")
+
+	// AUTO_MIGRATE applies pending database migrations on startup
+	AUTO_MIGRATE = getBool("AUTO_MIGRATE", false)
+
+	// Postgres connection pool sizing
+	PG_MAX_CONNS          = getInt("PG_MAX_CONNS", 20)
+	PG_MIN_CONNS          = getInt("PG_MIN_CONNS", 2)
+	PG_MAX_CONN_LIFETIME  = getDuration("PG_MAX_CONN_LIFETIME", time.Hour)
+	PG_MAX_CONN_IDLE_TIME = getDuration("PG_MAX_CONN_IDLE_TIME", 30*time.Minute)
+
+	// STORAGE_BACKEND is one of "s3", "local" or "memory"
+	STORAGE_BACKEND   = getEnv("STORAGE_BACKEND", "s3")
+	STORAGE_LOCAL_DIR = getEnv("STORAGE_LOCAL_DIR", "./data")
+	// PUBLIC_URL is the externally reachable base URL of this service
+	PUBLIC_URL = getEnv("PUBLIC_URL", "http://localhost:"+PORT)
+
+	// Lifetime of presigned share links when the caller does not ask for one,
+	// and the longest lifetime a caller may ask for
+	SHARE_LINK_DEFAULT_TTL = getDuration("SHARE_LINK_DEFAULT_TTL", time.Hour)
+	SHARE_LINK_MAX_TTL     = getDuration("SHARE_LINK_MAX_TTL", 7*24*time.Hour)
+
+	// How long deleted files stay in the trash before they are purged
+	TRASH_RETENTION = getDuration("TRASH_RETENTION", 30*24*time.Hour)
 )
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func getRate(key, fallback string) ratelimit.Rate {
+	rate, err := ratelimit.ParseRate(getEnv(key, fallback))
+	if err != nil {
+		rate, _ = ratelimit.ParseRate(fallback)
+	}
+	return rate
+}
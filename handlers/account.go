@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+	"trademarkia/apperr"
+	"trademarkia/config"
+	"trademarkia/mail"
+	"trademarkia/models"
+	"trademarkia/repository"
+	"trademarkia/tokens"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// sendVerificationEmail mails user a link that verifies their current email
+// address.
+func (s *AuthService) sendVerificationEmail(user *models.User) error {
+	msg, err := s.verificationEmail(user)
+	if err != nil {
+		return err
+	}
+	return s.Mailer.Send(context.Background(), msg)
+}
+
+func (s *AuthService) verificationEmail(user *models.User) (mail.Message, error) {
+	token, err := s.Tokens.IssueActionToken(tokens.PurposeVerifyEmail, user.ID.Hex(), user.Email, config.EMAIL_VERIFICATION_TTL)
+	if err != nil {
+		return mail.Message{}, err
+	}
+
+	link := config.PUBLIC_URL + "/verify-email?token=" + url.QueryEscape(token)
+	return mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening this link:\n\n%s\n\nThe link expires in %s.\n",
+			user.Username, link, config.EMAIL_VERIFICATION_TTL),
+	}, nil
+}
+
+// sendInBackground sends msg without waiting for the mail server, so how long
+// a request takes does not reveal whether an email was sent.
+func (s *AuthService) sendInBackground(msg mail.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := s.Mailer.Send(ctx, msg); err != nil {
+			log.Printf("Failed to send %q email: %v", msg.Subject, err)
+		}
+	}()
+}
+
+// VerifyEmailHandler marks an email address as verified. The token is taken
+// from the token query parameter, so the emailed link can be opened directly,
+// or from the request body.
+func (s *AuthService) VerifyEmailHandler(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		var req models.TokenRequest
+		if err := parseBody(c, &req); err != nil {
+			return err
+		}
+		token = req.Token
+	}
+
+	claims, err := s.Tokens.ParseActionToken(tokens.PurposeVerifyEmail, token)
+	if err != nil {
+		return apperr.Validation("Invalid or expired verification link")
+	}
+	user, err := s.Users.GetByID(context.Background(), claims.UserID)
+	if err == repository.ErrNotFound {
+		return apperr.Validation("Invalid or expired verification link")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to look up user", err)
+	}
+	// Links sent to an address the user has since changed are void
+	if !claims.Bound(user.Email) {
+		return apperr.Validation("Invalid or expired verification link")
+	}
+	if user.EmailVerified {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Email address already verified"})
+	}
+
+	if err := s.Tokens.Consume(context.Background(), claims); err != nil {
+		return consumeError(err)
+	}
+	if err := s.Users.MarkEmailVerified(context.Background(), user.ID.Hex(), user.Email); err != nil {
+		return apperr.Internal("Failed to verify email address", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Email address verified"})
+}
+
+// ResendVerificationHandler sends a new verification email. It answers the
+// same way whether or not the address belongs to an account.
+func (s *AuthService) ResendVerificationHandler(c *fiber.Ctx) error {
+	var req models.EmailRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+
+	user, err := s.Users.GetByEmail(context.Background(), req.Email)
+	if err != nil && err != repository.ErrNotFound {
+		return apperr.Internal("Failed to look up user", err)
+	}
+	if user != nil && !user.EmailVerified {
+		msg, err := s.verificationEmail(user)
+		if err != nil {
+			return apperr.Internal("Failed to create verification token", err)
+		}
+		s.sendInBackground(msg)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "If the address belongs to an unverified account, a verification email has been sent",
+	})
+}
+
+// ForgotPasswordHandler emails a password reset link. It answers the same way,
+// and as quickly, whether or not the address belongs to an account.
+func (s *AuthService) ForgotPasswordHandler(c *fiber.Ctx) error {
+	var req models.EmailRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+
+	user, err := s.Users.GetByEmail(context.Background(), req.Email)
+	if err != nil && err != repository.ErrNotFound {
+		return apperr.Internal("Failed to look up user", err)
+	}
+	if user != nil {
+		// Binding the token to the current password hash voids it, and every
+		// other outstanding reset link, once the password changes
+		token, err := s.Tokens.IssueActionToken(tokens.PurposeResetPassword, user.ID.Hex(), user.Password, config.PASSWORD_RESET_TTL)
+		if err != nil {
+			return apperr.Internal("Failed to create reset token", err)
+		}
+		s.sendInBackground(mail.Message{
+			To:      user.Email,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. If it was you, open this link:\n\n%s\n\nThe link expires in %s. If it was not you, you can ignore this email.\n",
+				user.Username, config.PASSWORD_RESET_URL+"?token="+url.QueryEscape(token), config.PASSWORD_RESET_TTL),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "If the address belongs to an account, a password reset email has been sent",
+	})
+}
+
+// ResetPasswordHandler sets a new password using a reset token and logs the
+// user out everywhere.
+func (s *AuthService) ResetPasswordHandler(c *fiber.Ctx) error {
+	var req models.ResetPassword
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+
+	claims, err := s.Tokens.ParseActionToken(tokens.PurposeResetPassword, req.Token)
+	if err != nil {
+		return apperr.Validation("Invalid or expired reset link")
+	}
+	user, err := s.Users.GetByID(context.Background(), claims.UserID)
+	if err == repository.ErrNotFound {
+		return apperr.Validation("Invalid or expired reset link")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to look up user", err)
+	}
+	if !claims.Bound(user.Password) {
+		return apperr.Validation("Invalid or expired reset link")
+	}
+	if err := s.Tokens.Consume(context.Background(), claims); err != nil {
+		return consumeError(err)
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return apperr.Internal("Failed to encrypt password", err)
+	}
+	if err := s.Users.UpdatePassword(context.Background(), user.ID.Hex(), string(hashedPassword)); err != nil {
+		return apperr.Internal("Failed to update password", err)
+	}
+	if err := s.revokeUserSessions(user.ID.Hex()); err != nil {
+		log.Println("Failed to revoke sessions:", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Password has been reset"})
+}
+
+// revokeUserSessions revokes every session of the user, with their refresh
+// tokens and access tokens.
+func (s *AuthService) revokeUserSessions(userID string) error {
+	return s.revokeOtherSessions(userID, "")
+}
+
+// revokeOtherSessions is revokeUserSessions sparing the session keepSessionID.
+func (s *AuthService) revokeOtherSessions(userID, keepSessionID string) error {
+	ctx := context.Background()
+	families, err := s.RefreshTokens.RevokeUser(ctx, userID, keepSessionID)
+	if err != nil {
+		return err
+	}
+	sessions, err := s.Sessions.ListActive(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.ID != keepSessionID {
+			families = append(families, session.ID)
+		}
+	}
+	for _, familyID := range families {
+		if err := s.endSession(ctx, familyID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func consumeError(err error) error {
+	if err == tokens.ErrTokenUsed {
+		return apperr.Validation("This link has already been used")
+	}
+	if err == tokens.ErrInvalidToken {
+		return apperr.Validation("This link has expired")
+	}
+	return apperr.Internal("Failed to use token", err)
+}
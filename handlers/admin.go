@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"time"
+	"trademarkia/apperr"
+	"trademarkia/cache"
+	"trademarkia/models"
+	"trademarkia/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// suspendedCacheTTL is how long a user's suspension status is cached for.
+// Suspending through the admin API updates the cache straight away.
+const suspendedCacheTTL = time.Minute
+
+func suspendedCacheKey(userID string) string {
+	return "auth:suspended:" + userID
+}
+
+// Suspended reports whether userID's account is suspended or no longer
+// exists. It is checked on every authenticated request, so the answer is
+// cached briefly.
+func (s *AuthService) Suspended(ctx context.Context, userID string) (bool, error) {
+	value, err := s.Cache.Get(ctx, suspendedCacheKey(userID))
+	if err == nil {
+		return value == "1", nil
+	}
+	if err != cache.ErrMiss {
+		return false, err
+	}
+
+	suspended := true
+	user, err := s.Users.GetByID(ctx, userID)
+	if err == nil {
+		suspended = user.Suspended
+	} else if err != repository.ErrNotFound {
+		return false, err
+	}
+	return suspended, s.cacheSuspended(ctx, userID, suspended)
+}
+
+func (s *AuthService) cacheSuspended(ctx context.Context, userID string, suspended bool) error {
+	value := "0"
+	if suspended {
+		value = "1"
+	}
+	return s.Cache.Set(ctx, suspendedCacheKey(userID), value, suspendedCacheTTL)
+}
+
+// targetUser loads the user named by the user_id parameter.
+func (s *AdminService) targetUser(c *fiber.Ctx) (*models.User, error) {
+	user, err := s.Auth.Users.GetByID(context.Background(), c.Params("user_id"))
+	if err == repository.ErrNotFound {
+		return nil, apperr.NotFound("User not found")
+	}
+	if err != nil {
+		return nil, apperr.Internal("Failed to look up user", err)
+	}
+	return user, nil
+}
+
+func (s *AdminService) ListUsersHandler(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	offset := c.QueryInt("offset", 0)
+	if limit < 1 || limit > 200 || offset < 0 {
+		return apperr.Validation("limit must be between 1 and 200 and offset not negative")
+	}
+
+	users, err := s.Auth.Users.List(context.Background(), limit, offset)
+	if err != nil {
+		return apperr.Internal("Failed to list users", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"users": users})
+}
+
+// UserFilesHandler lists a user's files, including the ones in their trash.
+func (s *AdminService) UserFilesHandler(c *fiber.Ctx) error {
+	user, err := s.targetUser(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	files, err := s.Files.Files.ListByUser(ctx, user.ID.Hex(), false)
+	if err != nil {
+		return apperr.Internal("Database query error", err)
+	}
+	trash, err := s.Files.Files.ListTrashed(ctx, user.ID.Hex())
+	if err != nil {
+		return apperr.Internal("Database query error", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"files": files,
+		"trash": trash,
+	})
+}
+
+// UserUsageHandler reports how many files a user has and how much storage
+// they take up, from the sizes recorded at upload.
+func (s *AdminService) UserUsageHandler(c *fiber.Ctx) error {
+	user, err := s.targetUser(c)
+	if err != nil {
+		return err
+	}
+
+	usage, err := s.Files.Files.Usage(context.Background(), user.ID.Hex())
+	if err != nil {
+		return apperr.Internal("Database query error", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"user_id":       user.ID.Hex(),
+		"files":         usage.Files,
+		"trashed_files": usage.TrashedFiles,
+		"bytes":         usage.Bytes,
+	})
+}
+
+// SetRoleHandler changes a user's role. A promotion applies to their access
+// tokens from the next refresh; a demotion logs them out everywhere, so they
+// lose admin access straight away.
+func (s *AdminService) SetRoleHandler(c *fiber.Ctx) error {
+	user, err := s.targetUser(c)
+	if err != nil {
+		return err
+	}
+	if user.ID.Hex() == c.Locals("userID") {
+		return apperr.Validation("You cannot change your own role")
+	}
+
+	var req models.RoleRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+
+	userID := user.ID.Hex()
+	if err := s.Auth.Users.SetRole(context.Background(), userID, req.Role); err != nil {
+		return apperr.Internal("Failed to update role", err)
+	}
+	if user.Role == models.RoleAdmin && req.Role != models.RoleAdmin {
+		if err := s.Auth.revokeUserSessions(userID); err != nil {
+			return apperr.Internal("Failed to revoke sessions", err)
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Role updated"})
+}
+
+// SuspendUserHandler suspends an account and logs it out everywhere. Its API
+// keys stop working too.
+func (s *AdminService) SuspendUserHandler(c *fiber.Ctx) error {
+	return s.setSuspended(c, true)
+}
+
+func (s *AdminService) UnsuspendUserHandler(c *fiber.Ctx) error {
+	return s.setSuspended(c, false)
+}
+
+func (s *AdminService) setSuspended(c *fiber.Ctx, suspended bool) error {
+	user, err := s.targetUser(c)
+	if err != nil {
+		return err
+	}
+	if user.ID.Hex() == c.Locals("userID") {
+		return apperr.Validation("You cannot suspend yourself")
+	}
+
+	ctx := context.Background()
+	userID := user.ID.Hex()
+	if err := s.Auth.Users.SetSuspended(ctx, userID, suspended); err != nil {
+		return apperr.Internal("Failed to update account", err)
+	}
+	if err := s.Auth.cacheSuspended(ctx, userID, suspended); err != nil {
+		return apperr.Internal("Failed to update account", err)
+	}
+
+	if !suspended {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Account unsuspended"})
+	}
+	if err := s.Auth.revokeUserSessions(userID); err != nil {
+		return apperr.Internal("Failed to revoke sessions", err)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Account suspended"})
+}
+
+// ForceDeleteFileHandler permanently deletes any file, skipping the trash.
+func (s *AdminService) ForceDeleteFileHandler(c *fiber.Ctx) error {
+	ctx := context.Background()
+	file, err := s.Files.Files.Get(ctx, c.Params("file_id"), "")
+	if err == repository.ErrNotFound {
+		return apperr.NotFound("File not found")
+	}
+	if err != nil {
+		return apperr.Internal("Database query error", err)
+	}
+
+	// Invalidate first, while the grants it reads still exist
+	if err := s.Files.invalidateFileSearchCache(file); err != nil {
+		log.Println("Error invalidating cache:", err)
+	}
+	if err := s.Files.Storage.Delete(ctx, file.FileID); err != nil {
+		return apperr.Internal("Failed to delete file contents", err)
+	}
+	if err := s.Files.Files.Delete(ctx, file.FileID); err != nil {
+		return apperr.Internal("Failed to delete file", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "File deleted permanently"})
+}
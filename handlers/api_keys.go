@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"time"
+	"trademarkia/apperr"
+	"trademarkia/models"
+	"trademarkia/repository"
+	"trademarkia/tokens"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// apiKeyPrefix starts every API key, so leaked keys are easy to recognise
+const apiKeyPrefix = "tmk_"
+
+// CreateAPIKeyHandler creates an API key for the caller. The key itself is
+// only returned here.
+func (s *AuthService) CreateAPIKeyHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok || userID == "" {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	var req models.APIKeyRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+	scopes, err := parseScopes(req.Scopes)
+	if err != nil {
+		return err
+	}
+
+	secret, err := tokens.NewOpaqueToken()
+	if err != nil {
+		return apperr.Internal("Failed to create API key", err)
+	}
+	key := apiKeyPrefix + secret
+	apiKey := &models.APIKey{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Name:      req.Name,
+		Prefix:    key[:len(apiKeyPrefix)+6],
+		KeyHash:   tokens.HashOpaqueToken(key),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := s.APIKeys.Create(context.Background(), apiKey); err != nil {
+		return apperr.Internal("Failed to create API key", err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"api_key": apiKey,
+		"key":     key,
+	})
+}
+
+// ListAPIKeysHandler lists the caller's API keys.
+func (s *AuthService) ListAPIKeysHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok || userID == "" {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	keys, err := s.APIKeys.ListByUser(context.Background(), userID)
+	if err != nil {
+		return apperr.Internal("Failed to list API keys", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"api_keys": keys})
+}
+
+// RevokeAPIKeyHandler deletes one of the caller's API keys.
+func (s *AuthService) RevokeAPIKeyHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok || userID == "" {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	err := s.APIKeys.Delete(context.Background(), userID, c.Params("key_id"))
+	if err == repository.ErrNotFound {
+		return apperr.NotFound("API key not found")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to revoke API key", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "API key revoked"})
+}
+
+// parseScopes checks the requested scopes. A key created without scopes gets
+// all of them.
+func parseScopes(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return models.Scopes, nil
+	}
+	want := map[string]bool{}
+	for _, scope := range requested {
+		want[scope] = true
+	}
+
+	scopes := []string{}
+	for _, scope := range models.Scopes {
+		if want[scope] {
+			scopes = append(scopes, scope)
+			delete(want, scope)
+		}
+	}
+	for scope := range want {
+		return nil, apperr.Validation("Unknown scope: " + scope)
+	}
+	return scopes, nil
+}
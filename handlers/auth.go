@@ -4,119 +4,136 @@ package handlers
 import (
 	"context"
 	"log"
-	"time"
-	"trademarkia/config"
+	"trademarkia/apperr"
 	"trademarkia/models"
+	"trademarkia/repository"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gofiber/fiber/v2"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func SignupHandler(c *fiber.Ctx) error {
+func (s *AuthService) SignupHandler(c *fiber.Ctx) error {
 	var user models.SignupUser
-	if err := c.BodyParser(&user); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
+	if err := parseBody(c, &user); err != nil {
+		return err
 	}
 
-	existingUser := bson.M{"email": user.Email}
-	count, err := collection.CountDocuments(context.Background(), existingUser)
+	emailTaken, err := s.Users.EmailExists(context.Background(), user.Email)
 	if err != nil {
-		log.Fatal(err)
+		return apperr.Internal("Failed to look up user", err)
 	}
 
-	existingUsername := bson.M{"username": user.Username}
-	countuser, err := collection.CountDocuments(context.Background(), existingUsername)
+	usernameTaken, err := s.Users.UsernameExists(context.Background(), user.Username)
 	if err != nil {
-		log.Fatal(err)
+		return apperr.Internal("Failed to look up user", err)
 	}
 
-	if count > 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "User with this email already exists",
-		})
+	if emailTaken {
+		return apperr.Conflict("User with this email already exists")
 	}
 
-	if countuser > 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "User with this username already exists",
-		})
+	if usernameTaken {
+		return apperr.Conflict("User with this username already exists")
 	}
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to encrypt password",
-		})
+		return apperr.Internal("Failed to encrypt password", err)
 	}
-	newUser := bson.M{
-		"email":    user.Email,
-		"username": user.Username,
-		"password": string(hashedPassword),
+	newUser := &models.User{
+		Email:    user.Email,
+		Username: user.Username,
+		Password: string(hashedPassword),
+		Role:     models.RoleUser,
 	}
 
-	_, err = collection.InsertOne(context.Background(), newUser)
+	// Checked again by the unique indexes, for sign-ups racing each other
+	err = s.Users.Create(context.Background(), newUser)
+	if err == repository.ErrDuplicate {
+		return apperr.Conflict("User with this email or username already exists")
+	}
 	if err != nil {
-		log.Fatal(err)
+		return apperr.Internal("Failed to create user", err)
+	}
+
+	// The account exists either way; the user can ask for another email
+	if err := s.sendVerificationEmail(newUser); err != nil {
+		log.Println("Failed to send verification email:", err)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message": "Signed up successfully!",
+		"message": "Signed up successfully! Check your email to verify your address.",
 	})
 }
 
-func LoginHandler(c *fiber.Ctx) error {
-	var loginCredentials models.LoginUser
-	if err := c.BodyParser(&loginCredentials); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
-	}
-
-	storedPassword, userID := getPasswordAndIDFromDatabase(loginCredentials.Email)
+// dummyPasswordHash is checked against, and the result ignored, when there is
+// no password to check, so that takes as long as a wrong password.
+const dummyPasswordHash = "$2a$10$x4Ac1VJF2ug3Op4CsBnhNu6O6onS4kNbnYS504Z10BINRUkct.Ch2"
 
-	err := bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(loginCredentials.Password))
-	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"message": "Invalid credentials",
-		})
+// checkPassword reports whether password is user's. user may be nil, or have
+// no password, which never matches.
+func checkPassword(user *models.User, password string) bool {
+	if user == nil || user.Password == "" {
+		bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(password))
+		return false
 	}
-
-	token := generateToken(userID.Hex())
-
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message": "Login successful!",
-		"token":   token,
-	})
+	return bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil
 }
 
-func getPasswordAndIDFromDatabase(email string) (string, primitive.ObjectID) {
-	var result bson.M
-	filter := bson.M{"email": email}
-	err := collection.FindOne(context.Background(), filter).Decode(&result)
-	if err != nil {
-		log.Fatal(err)
+func (s *AuthService) LoginHandler(c *fiber.Ctx) error {
+	var loginCredentials models.LoginUser
+	if err := parseBody(c, &loginCredentials); err != nil {
+		return err
 	}
 
-	hashedPassword := result["password"].(string)
-	userID := result["_id"].(primitive.ObjectID)
-	return hashedPassword, userID
-}
-
-func generateToken(userID string) string {
-	token := jwt.New(jwt.SigningMethodHS256)
+	ctx := context.Background()
+	lockoutKey := loginLockoutKey(loginCredentials.Email)
+	lockedUntil, err := s.Limiter.LockedUntil(ctx, lockoutKey)
+	if err != nil {
+		return apperr.Internal("Failed to check account lockout", err)
+	}
+	if !lockedUntil.IsZero() {
+		return lockedError(lockedUntil)
+	}
 
-	claims := token.Claims.(jwt.MapClaims)
-	claims["_id"] = userID
-	claims["exp"] = time.Now().Add(time.Hour * 72).Unix() // Add expiration time
+	// Unknown emails and wrong passwords get the same answer, in the same
+	// time, so the response does not reveal which accounts exist
+	user, err := s.Users.GetByEmail(ctx, loginCredentials.Email)
+	if err == repository.ErrNotFound {
+		user = nil
+	} else if err != nil {
+		return apperr.Internal("Failed to look up user", err)
+	}
+	if !checkPassword(user, loginCredentials.Password) {
+		lockedUntil, err := s.Limiter.Fail(ctx, lockoutKey, loginLockout())
+		if err != nil {
+			return apperr.Internal("Failed to record failed login", err)
+		}
+		if !lockedUntil.IsZero() {
+			return lockedError(lockedUntil)
+		}
+		return apperr.Unauthorized("Invalid credentials")
+	}
+	if user.Suspended {
+		return apperr.Forbidden("Account has been suspended")
+	}
+	if s.RequireEmailVerification && !user.EmailVerified {
+		return apperr.Forbidden("Email address has not been verified")
+	}
+	// With two-factor authentication the failures are only forgotten once
+	// the second factor is right too
+	if user.MFAEnabled {
+		return s.mfaChallenge(c, user)
+	}
+	if err := s.Limiter.Reset(ctx, lockoutKey); err != nil {
+		return apperr.Internal("Failed to reset failed logins", err)
+	}
 
-	tokenString, err := token.SignedString([]byte(config.SECRET_KEY))
+	// Each login starts a new session, which is also its token family
+	pair, err := s.startSession(c, user)
 	if err != nil {
-		log.Fatal(err)
+		return apperr.Internal("Failed to create token", err)
 	}
+	pair["message"] = "Login successful!"
 
-	return tokenString
+	return c.Status(fiber.StatusOK).JSON(pair)
 }
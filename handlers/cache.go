@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"trademarkia/cache"
+	"trademarkia/models"
+)
+
+// searchCacheGenerationKey holds a counter that is embedded in every cached
+// search key for the user.
+func searchCacheGenerationKey(userID string) string {
+	return fmt.Sprintf("files:gen:%s", userID)
+}
+
+func (s *FileService) searchCacheGeneration(userID string) (int64, error) {
+	value, err := s.Cache.Get(context.Background(), searchCacheGenerationKey(userID))
+	if err == cache.ErrMiss {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// bumpSearchCacheGeneration invalidates the cached search results of each
+// user. Stale entries are never read again and expire on their own.
+func (s *FileService) bumpSearchCacheGeneration(userIDs ...string) error {
+	for _, userID := range userIDs {
+		if _, err := s.Cache.Incr(context.Background(), searchCacheGenerationKey(userID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// invalidateFileSearchCache drops the cached search results of everyone who
+// can see file: its owner and the users it has been shared with.
+func (s *FileService) invalidateFileSearchCache(file *models.File) error {
+	grants, err := s.Permissions.List(context.Background(), file.FileID)
+	if err != nil {
+		return err
+	}
+
+	userIDs := []string{file.UserID}
+	for _, grant := range grants {
+		userIDs = append(userIDs, grant.UserID)
+	}
+	return s.bumpSearchCacheGeneration(userIDs...)
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"trademarkia/apperr"
+	"trademarkia/models"
+	"trademarkia/storage"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var errUnsatisfiableRange = errors.New("unsatisfiable range")
+
+func (s *FileService) DownloadFileHandler(c *fiber.Ctx) error {
+	file := c.Locals("file").(*models.File)
+	return s.serveFile(c, file.FileID, file.Filename)
+}
+
+// serveFile streams the stored object for fileID to the client, honouring
+// If-None-Match and single byte-range requests so players can seek.
+func (s *FileService) serveFile(c *fiber.Ctx, fileID, filename string) error {
+	ctx := context.Background()
+
+	info, err := s.Storage.Stat(ctx, fileID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return apperr.NotFound("File not found")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to read file", err)
+	}
+
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(filename))
+	}
+	if contentType == "" {
+		contentType = fiber.MIMEOctetStream
+	}
+	disposition := "inline"
+	if c.QueryBool("download") {
+		disposition = "attachment"
+	}
+
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+	if info.ETag != "" {
+		c.Set(fiber.HeaderETag, info.ETag)
+	}
+	if !info.LastModified.IsZero() {
+		c.Set(fiber.HeaderLastModified, info.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if etagMatches(c.Get(fiber.HeaderIfNoneMatch), info.ETag) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, mime.FormatMediaType(disposition, map[string]string{"filename": filename}))
+
+	rangeHeader := c.Get(fiber.HeaderRange)
+	if ifRange := c.Get(fiber.HeaderIfRange); ifRange != "" && ifRange != info.ETag {
+		// The client's copy is stale, so send the whole object instead
+		rangeHeader = ""
+	}
+
+	offset, length, err := parseRange(rangeHeader, info.Size)
+	if errors.Is(err, errUnsatisfiableRange) {
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", info.Size))
+		return apperr.New(apperr.CodeRangeNotSatisfiable, "Requested range not satisfiable")
+	}
+	if err != nil || length == info.Size {
+		body, info, err := s.Storage.Get(ctx, fileID)
+		if err != nil {
+			return apperr.Internal("Failed to read file", err)
+		}
+		return c.Status(fiber.StatusOK).SendStream(body, int(info.Size))
+	}
+
+	body, _, err := s.Storage.GetRange(ctx, fileID, offset, length)
+	if err != nil {
+		return apperr.Internal("Failed to read file", err)
+	}
+	c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, info.Size))
+	return c.Status(fiber.StatusPartialContent).SendStream(body, int(length))
+}
+
+// parseRange parses a single "bytes=" range against an object of the given
+// size. Missing, malformed and multi-range headers return an error so the
+// caller falls back to sending the whole object.
+func parseRange(header string, size int64) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, size, errors.New("no single byte range")
+	}
+	startStr, endStr, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, size, errors.New("malformed range")
+	}
+
+	// Suffix range: the last N bytes
+	if startStr == "" {
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil {
+			return 0, size, err
+		}
+		if n <= 0 || size == 0 {
+			return 0, 0, errUnsatisfiableRange
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, nil
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return 0, size, errors.New("malformed range")
+	}
+	if start >= size {
+		return 0, 0, errUnsatisfiableRange
+	}
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return 0, size, errors.New("malformed range")
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, nil
+}
+
+func etagMatches(header, etag string) bool {
+	if header == "" || etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
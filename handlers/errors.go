@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"trademarkia/apperr"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+)
+
+// ErrorHandler writes every error returned by a handler or middleware as
+//
+//	{"error": {"code": ..., "message": ..., "request_id": ...}}
+//
+// with a "details" object added when the error has any.
+// Errors that are not an *apperr.Error are unexpected: they are logged and
+// reported to the client as internal errors without their details.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	requestID, _ := c.Locals(requestid.ConfigDefault.ContextKey).(string)
+
+	status := http.StatusInternalServerError
+	code := apperr.CodeInternal
+	message := "Internal server error"
+	var details map[string]interface{}
+
+	var fiberErr *fiber.Error
+	if e, ok := apperr.As(err); ok {
+		status, code, message, details = e.Code.Status(), e.Code, e.Message, e.Details
+	} else if errors.As(err, &fiberErr) {
+		// Raised by Fiber itself, e.g. for unknown routes or oversized bodies
+		status, code, message = fiberErr.Code, codeForStatus(fiberErr.Code), fiberErr.Message
+	}
+
+	if retryAfter, ok := details["retry_after"].(int); ok {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	}
+	if status >= http.StatusInternalServerError {
+		log.Printf("Request %s %s %s failed: %v", requestID, c.Method(), c.Path(), err)
+	}
+
+	body := fiber.Map{
+		"code":       code,
+		"message":    message,
+		"request_id": requestID,
+	}
+	if details != nil {
+		body["details"] = details
+	}
+	return c.Status(status).JSON(fiber.Map{"error": body})
+}
+
+func codeForStatus(status int) apperr.Code {
+	switch status {
+	case http.StatusBadRequest:
+		return apperr.CodeValidation
+	case http.StatusUnauthorized:
+		return apperr.CodeUnauthorized
+	case http.StatusForbidden:
+		return apperr.CodeForbidden
+	case http.StatusNotFound:
+		return apperr.CodeNotFound
+	case http.StatusConflict:
+		return apperr.CodeConflict
+	}
+	if status >= http.StatusInternalServerError {
+		return apperr.CodeInternal
+	}
+	// e.g. "method_not_allowed"
+	return apperr.Code(strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_"))
+}
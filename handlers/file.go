@@ -1,314 +1,314 @@
 package handlers
 
 import (
-	"bytes"
+	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
-	"sync"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
 	"time"
-	"trademarkia/config"
+	"trademarkia/apperr"
+	"trademarkia/cache"
+	"trademarkia/models"
+	"trademarkia/repository"
+	"trademarkia/storage"
+	"trademarkia/validation"
+	"unicode/utf8"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/go-redis/redis"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v4"
 )
 
-func UploadHandler(c *fiber.Ctx) error {
-	log.Println("UploadHandler called")
-
+func (s *FileService) UploadHandler(c *fiber.Ctx) error {
 	// Extract userID from JWT token claim
 	userID, ok := c.Locals("userID").(string)
 	if !ok || userID == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+		return apperr.Unauthorized("Unauthorized")
 	}
 
 	file, err := c.FormFile("file")
 	if err != nil {
-		log.Println("Failed to get file:", err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Failed to get file"})
+		return apperr.Validation("Failed to get file")
+	}
+	upload := models.UploadedFile{Filename: validation.SanitizeFilename(file.Filename)}
+	if err := validation.Struct(&upload); err != nil {
+		return err
 	}
 
 	fileContent, err := file.Open()
 	if err != nil {
-		log.Println("Failed to open file:", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to open file"})
+		return apperr.Internal("Failed to open file", err)
 	}
 	defer fileContent.Close()
 
-	fileID := uuid.New().String()
-	s3URL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", config.S3_BUCKET, config.AWS_REGION, fileID)
-
-	chunkSize := 10 * 1024 * 1024 // 10 MB
-	var wg sync.WaitGroup
-	chunkChannel := make(chan []byte, 10)
-	errorChannel := make(chan error, 1)
-
-	go func() {
-		defer close(chunkChannel)
-		buffer := make([]byte, chunkSize)
-		for {
-			n, err := fileContent.Read(buffer)
-			if n > 0 {
-				chunk := make([]byte, n)
-				copy(chunk, buffer[:n])
-				chunkChannel <- chunk
-			}
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				errorChannel <- err
-				return
-			}
-		}
-	}()
-
-	for chunk := range chunkChannel {
-		wg.Add(1)
-		go func(data []byte) {
-			defer wg.Done()
-			if err := uploadChunkToS3(data, fileID); err != nil {
-				errorChannel <- err
-			}
-		}(chunk)
+	// The file is read once: the content type is sniffed from its first
+	// bytes, and the checksum is worked out while it streams to storage
+	content := bufio.NewReaderSize(fileContent, sniffLength)
+	head, err := content.Peek(sniffLength)
+	if err != nil && err != io.EOF {
+		return apperr.Internal("Failed to read file", err)
 	}
+	contentType := detectContentType(head, upload.Filename)
 
-	wg.Wait()
-	close(errorChannel)
+	fileID := uuid.New().String()
+	s3URL := s.Storage.URL(fileID)
 
-	if err, ok := <-errorChannel; ok {
-		log.Println("Failed to upload to S3:", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to upload to S3"})
+	ctx := context.Background()
+	hash := sha256.New()
+	err = s.Storage.Put(ctx, fileID, io.TeeReader(content, hash), storage.PutOptions{ContentType: contentType})
+	if err != nil {
+		return apperr.Internal("Failed to upload file", err)
 	}
 
-	if err := saveFileMetadata(file.Filename, fileID, s3URL, userID); err != nil {
-		log.Println("Failed to save metadata:", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save metadata"})
+	uploaded := &models.File{
+		FileID:      fileID,
+		Filename:    upload.Filename,
+		UploadDate:  time.Now(),
+		S3URL:       s3URL,
+		UserID:      userID,
+		FileSize:    file.Size,
+		ContentType: contentType,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+	}
+	err = s.Files.Create(ctx, uploaded)
+	if err != nil {
+		if err := s.Storage.Delete(ctx, fileID); err != nil {
+			log.Println("Error removing upload:", err)
+		}
+		return apperr.Internal("Failed to save metadata", err)
 	}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"url": s3URL})
-}
+	if err := s.bumpSearchCacheGeneration(userID); err != nil {
+		log.Println("Error invalidating cache:", err)
+	}
 
-func uploadChunkToS3(chunk []byte, fileID string) error {
-	_, err := S3Client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(config.S3_BUCKET),
-		Key:    aws.String(fileID),
-		Body:   bytes.NewReader(chunk),
-	})
-	return err
+	// Answered in the same shape as /files, as it would be listed
+	uploaded.Permission = PermissionOwner.String()
+	uploaded.Tags = []string{}
+	uploaded.Version = 1
+	return c.Status(fiber.StatusOK).JSON(uploaded)
 }
 
-func saveFileMetadata(filename, fileID, s3URL, userID string) error {
-	conn, err := pgx.Connect(context.Background(), getPostgresURL())
-	if err != nil {
-		log.Println("Database Connection Error:", err)
-		return err
+// sniffLength is how much of an upload is read to detect its content type,
+// which is all http.DetectContentType looks at.
+const sniffLength = 512
+
+// detectContentType sniffs the content type of an upload from its first
+// bytes, falling back to the extension when they are not recognised.
+func detectContentType(head []byte, filename string) string {
+	contentType := http.DetectContentType(head)
+	if contentType == "application/octet-stream" {
+		if byExtension := mime.TypeByExtension(path.Ext(filename)); byExtension != "" {
+			contentType = byExtension
+		}
 	}
-	defer conn.Close(context.Background())
-
-	_, err = conn.Exec(context.Background(), `INSERT INTO files (file_id, filename, upload_date, s3_url, user_id) VALUES ($1, $2, $3, $4, $5)`,
-		fileID, filename, time.Now(), s3URL, userID)
-	return err
+	return contentType
 }
 
-func getPostgresURL() string {
-	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s",
-		config.PG_USER,
-		config.PG_PASSWORD,
-		config.PG_HOST,
-		config.PG_PORT,
-		config.PG_DBNAME,
-	)
+// parseView reports whether the view query parameter asks for the files
+// other users have shared with the caller ("shared") rather than the caller's
+// own uploads ("own", the default).
+func parseView(view string) (bool, error) {
+	switch view {
+	case "", "own":
+		return false, nil
+	case "shared":
+		return true, nil
+	default:
+		return false, fmt.Errorf("view must be own or shared")
+	}
 }
 
-func GetFilesHandler(c *fiber.Ctx) error {
+func (s *FileService) GetFilesHandler(c *fiber.Ctx) error {
 	userID, ok := c.Locals("userID").(string)
 	if !ok || userID == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+		return apperr.Unauthorized("Unauthorized")
 	}
 
-	conn, err := pgx.Connect(context.Background(), getPostgresURL())
+	shared, err := parseView(c.Query("view"))
 	if err != nil {
-		log.Println("Database Connection Error:", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database connection error"})
+		return apperr.Validation(err.Error())
 	}
-	defer conn.Close(context.Background())
 
-	rows, err := conn.Query(context.Background(), `SELECT file_id, filename, upload_date, s3_url FROM files WHERE user_id = $1`, userID)
+	files, err := s.Files.ListByUser(context.Background(), userID, shared)
 	if err != nil {
-		log.Println("Database Query Error:", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database query error"})
-	}
-	defer rows.Close()
-
-	files := []fiber.Map{}
-	for rows.Next() {
-		var fileID, filename, s3URL string
-		var uploadDate time.Time
-		if err := rows.Scan(&fileID, &filename, &uploadDate, &s3URL); err != nil {
-			log.Println("Database Scan Error:", err)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database scan error"})
-		}
-		files = append(files, fiber.Map{
-			"file_id":     fileID,
-			"filename":    filename,
-			"upload_date": uploadDate,
-			"s3_url":      s3URL,
-		})
+		return apperr.Internal("Database query error", err)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(files)
 }
 
-func ShareFileHandler(c *fiber.Ctx) error {
-	fileID := c.Params("file_id")
-
-	// Retrieve metadata
-	conn, err := pgx.Connect(context.Background(), getPostgresURL())
-	if err != nil {
-		log.Println("Database Connection Error:", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to connect to database"})
-	}
-	defer conn.Close(context.Background())
-
-	var objectKey string
-	err = conn.QueryRow(context.Background(), `SELECT s3_url FROM files WHERE file_id = $1`, fileID).Scan(&objectKey)
-	if err != nil {
-		log.Println("Database Query Error:", err)
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "File not found"})
+func (s *FileService) SearchFilesHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok || userID == "" {
+		return apperr.Unauthorized("Unauthorized")
 	}
-
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"share_url": objectKey})
-}
-
-func SearchFilesHandler(c *fiber.Ctx) error {
-	userID := c.Locals("userID").(string)
 	name := c.Query("name")
 	date := c.Query("date")
 	limit := c.QueryInt("limit", 10)  // Default limit
 	offset := c.QueryInt("offset", 0) // Default offset
+	view := c.Query("view", "own")
 
-	// Format cache key
-	cacheKey := fmt.Sprintf("files:%s:%s:%s:%d:%d", userID, name, date, limit, offset)
-	fmt.Printf("Cache Key: %s\n", cacheKey)
-
-	// Attempt to retrieve from cache
-	cachedData, err := RedisClient.Get(context.Background(), cacheKey).Result()
-	if err != redis.Nil {
-		fmt.Println("Cache miss, querying database...")
-
-		// Prepare SQL query
-		var query string
-		params := []interface{}{userID}
-		paramIndex := 2
-
-		query = `SELECT file_id, filename, upload_date, s3_url FROM files WHERE user_id = $1`
-
-		if name != "" {
-			query += fmt.Sprintf(` AND filename ILIKE $%d`, paramIndex)
-			params = append(params, "%"+name+"%")
-			paramIndex++
-		}
-		if date != "" {
-			query += fmt.Sprintf(` AND upload_date::date = $%d`, paramIndex)
-			params = append(params, date)
-			paramIndex++
-		}
-		if limit > 0 {
-			query += fmt.Sprintf(` LIMIT $%d OFFSET $%d`, paramIndex, paramIndex+1)
-			params = append(params, limit, offset)
-		}
+	shared, err := parseView(view)
+	if err != nil {
+		return apperr.Validation(err.Error())
+	}
+	if limit < 1 || limit > 100 || offset < 0 {
+		return apperr.Validation("limit must be between 1 and 100 and offset not negative")
+	}
+	day, err := parseSearchDate(date)
+	if err != nil {
+		return apperr.Validation("date must be formatted as YYYY-MM-DD")
+	}
 
-		// Connect to database
-		conn, err := pgx.Connect(context.Background(), getPostgresURL())
-		if err != nil {
-			log.Println("Database Connection Error:", err)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database connection error"})
-		}
-		defer conn.Close(context.Background())
+	// Format cache key. The user's cache generation is part of the key, so
+	// bumping it makes every older result unreachable.
+	generation, err := s.searchCacheGeneration(userID)
+	if err != nil {
+		return apperr.Internal("Cache error", err)
+	}
+	cacheKey := fmt.Sprintf("files:%s:%d:%s:%s:%s:%d:%d", userID, generation, view, name, date, limit, offset)
 
-		// Execute query
-		rows, err := conn.Query(context.Background(), query, params...)
+	// Attempt to retrieve from cache
+	cachedData, err := s.Cache.Get(context.Background(), cacheKey)
+	if err == cache.ErrMiss {
+		files, err := s.Files.Search(context.Background(), repository.SearchParams{
+			UserID: userID,
+			Shared: shared,
+			Name:   name,
+			Date:   day,
+			Limit:  limit,
+			Offset: offset,
+		})
 		if err != nil {
-			log.Println("Database Query Error:", err)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database query error"})
-		}
-		defer rows.Close()
-
-		// Collect results
-		files := []fiber.Map{}
-		for rows.Next() {
-			var fileID, filename, s3URL string
-			var uploadDate time.Time
-			if err := rows.Scan(&fileID, &filename, &uploadDate, &s3URL); err != nil {
-				log.Println("Database Scan Error:", err)
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database scan error"})
-			}
-			files = append(files, fiber.Map{
-				"file_id":     fileID,
-				"filename":    filename,
-				"upload_date": uploadDate,
-				"s3_url":      s3URL,
-			})
+			return apperr.Internal("Database query error", err)
 		}
 
 		// Cache result
 		filesJSON, _ := json.Marshal(files)
-		err = RedisClient.Set(context.Background(), cacheKey, filesJSON, 5*time.Minute).Err()
+		err = s.Cache.Set(context.Background(), cacheKey, string(filesJSON), 5*time.Minute)
 		if err != nil {
 			log.Println("Error setting cache:", err)
-		} else {
-			fmt.Println("Data cached successfully.")
 		}
 
 		return c.Status(fiber.StatusOK).JSON(files)
 	} else if err != nil {
-		log.Println("Redis Error:", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Redis error"})
+		return apperr.Internal("Cache error", err)
 	} else {
-		fmt.Println("Cache hit, returning cached data...")
-		var files []fiber.Map
+		var files []models.File
 		err = json.Unmarshal([]byte(cachedData), &files)
 		if err != nil {
-			log.Println("Error unmarshalling cache data:", err)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cache data error"})
+			return apperr.Internal("Cache data error", err)
 		}
 		return c.Status(fiber.StatusOK).JSON(files)
 	}
 }
 
-func UpdateFileMetadataHandler(c *fiber.Ctx) error {
-	fileID := c.Params("file_id")
-	newName := c.Query("name")
+// UpdateFileMetadataHandler renames a file and updates its description and
+// tags. Fields left out of the body are not changed. Clients can send the
+// version they last saw in If-Match (or as "version" in the body) to make sure
+// they do not overwrite someone else's change.
+func (s *FileService) UpdateFileMetadataHandler(c *fiber.Ctx) error {
+	file := c.Locals("file").(*models.File)
 
-	// Update metadata in the database
-	conn, err := pgx.Connect(context.Background(), getPostgresURL())
-	if err != nil {
-		log.Println("Database Connection Error:", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database connection error"})
+	var req models.UpdateFileMetadata
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+	if req.Filename == nil && req.Description == nil && req.Tags == nil {
+		return apperr.Validation("Nothing to update")
+	}
+	if req.Filename != nil {
+		name := strings.TrimSpace(*req.Filename)
+		req.Filename = &name
+	}
+	if req.Tags != nil {
+		tags, err := normalizeTags(*req.Tags)
+		if err != nil {
+			return apperr.Validation(err.Error())
+		}
+		req.Tags = &tags
 	}
-	defer conn.Close(context.Background())
 
-	_, err = conn.Exec(context.Background(), `UPDATE files SET filename = $1 WHERE file_id = $2`, newName, fileID)
+	expectedVersion := req.Version
+	if ifMatch := c.Get(fiber.HeaderIfMatch); ifMatch != "" {
+		version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`))
+		if err != nil {
+			return apperr.PreconditionFailed("Invalid If-Match header")
+		}
+		expectedVersion = &version
+	}
+
+	updated, err := s.Files.Update(context.Background(), file.FileID, repository.FileUpdate{
+		Filename:    req.Filename,
+		Description: req.Description,
+		Tags:        req.Tags,
+	}, expectedVersion)
+	if err == repository.ErrVersionConflict {
+		return apperr.PreconditionFailed("File was modified by someone else")
+	}
+	if err == repository.ErrNotFound {
+		return apperr.NotFound("File not found")
+	}
 	if err != nil {
-		log.Println("Database Update Error:", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update file metadata"})
+		return apperr.Internal("Failed to update file metadata", err)
+	}
+	updated.Permission = file.Permission
+
+	if err := s.invalidateFileSearchCache(file); err != nil {
+		log.Println("Error invalidating cache:", err)
 	}
 
-	// Invalidate the cache
-	cacheKey := fmt.Sprintf("files:*:*:*:*:*") // Pattern to invalidate all cache for this user
-	keys, err := RedisClient.Keys(context.Background(), cacheKey).Result()
-	if err == nil {
-		for _, key := range keys {
-			RedisClient.Del(context.Background(), key)
+	c.Set(fiber.HeaderETag, fmt.Sprintf(`"%d"`, updated.Version))
+	return c.Status(fiber.StatusOK).JSON(updated)
+}
+
+// parseSearchDate reads the date filter of a search, either a day or a
+// timestamp, in which case its day is used. An empty value matches any day.
+func parseSearchDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	var err error
+	for _, layout := range []string{time.DateOnly, "2006-01-02 15:04:05.999999999", time.RFC3339Nano} {
+		var parsed time.Time
+		if parsed, err = time.Parse(layout, value); err == nil {
+			return parsed, nil
 		}
 	}
+	return time.Time{}, err
+}
+
+const (
+	maxTags      = 20
+	maxTagLength = 50
+)
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "File metadata updated successfully"})
+// normalizeTags trims and de-duplicates tags, keeping their original order.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("tags must be at most %d characters", maxTagLength)
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxTags {
+		return nil, fmt.Errorf("a file can have at most %d tags", maxTags)
+	}
+	return normalized, nil
 }
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"time"
+	"trademarkia/apperr"
+	"trademarkia/config"
+	"trademarkia/ratelimit"
+	"trademarkia/validation"
+)
+
+// Failed logins are counted per account email. Once there have been
+// LOGIN_LOCKOUT_THRESHOLD in a row the account is locked, and each further
+// failure doubles the lockout. The count is forgotten after a day without
+// failures or on a successful login. The limiter keeps the count, so it falls
+// back to memory with the rate limits when Redis is unavailable.
+const loginFailureMemory = 24 * time.Hour
+
+func loginLockout() ratelimit.Lockout {
+	return ratelimit.Lockout{
+		Threshold: config.LOGIN_LOCKOUT_THRESHOLD,
+		Base:      config.LOGIN_LOCKOUT_BASE,
+		Max:       config.LOGIN_LOCKOUT_MAX,
+		Memory:    loginFailureMemory,
+	}
+}
+
+func loginLockoutKey(email string) string {
+	return "login:" + validation.NormalizeEmail(email)
+}
+
+// lockedError tells the client when they can try logging in again.
+func lockedError(until time.Time) error {
+	e := apperr.TooManyRequests("Too many failed logins, the account is locked until "+until.UTC().Format(time.RFC3339), time.Until(until))
+	e.Details["locked_until"] = until.UTC()
+	return e
+}
@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"strings"
+	"time"
+	"trademarkia/apperr"
+	"trademarkia/config"
+	"trademarkia/models"
+	"trademarkia/ratelimit"
+	"trademarkia/repository"
+	"trademarkia/tokens"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	recoveryCodeCount = 10
+	// maxMFAAttempts is how many wrong codes can be tried with one MFA token
+	maxMFAAttempts = 5
+)
+
+var totpOpts = totp.ValidateOpts{
+	Period:    30,
+	Skew:      1,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// currentUser loads the user the request is authenticated as.
+func (s *AuthService) currentUser(c *fiber.Ctx) (*models.User, error) {
+	userID, ok := c.Locals("userID").(string)
+	if !ok || userID == "" {
+		return nil, apperr.Unauthorized("Unauthorized")
+	}
+	user, err := s.Users.GetByID(context.Background(), userID)
+	if err == repository.ErrNotFound {
+		return nil, apperr.Unauthorized("User no longer exists")
+	}
+	if err != nil {
+		return nil, apperr.Internal("Failed to look up user", err)
+	}
+	return user, nil
+}
+
+// EnrollTOTPHandler generates a new TOTP secret for the caller. Two-factor
+// authentication is only turned on once a code from it has been confirmed.
+func (s *AuthService) EnrollTOTPHandler(c *fiber.Ctx) error {
+	user, err := s.currentUser(c)
+	if err != nil {
+		return err
+	}
+	if user.MFAEnabled {
+		return apperr.Conflict("Two-factor authentication is already enabled")
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      config.TOTP_ISSUER,
+		AccountName: user.Email,
+	})
+	if err != nil {
+		return apperr.Internal("Failed to generate secret", err)
+	}
+	if err := s.Users.SetTOTPSecret(context.Background(), user.ID.Hex(), key.Secret()); err != nil {
+		return apperr.Internal("Failed to save secret", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"secret":      key.Secret(),
+		"otpauth_url": key.URL(),
+	})
+}
+
+// ConfirmTOTPHandler turns on two-factor authentication once the caller shows
+// a valid code, and returns their recovery codes. They are not shown again.
+func (s *AuthService) ConfirmTOTPHandler(c *fiber.Ctx) error {
+	user, err := s.currentUser(c)
+	if err != nil {
+		return err
+	}
+	if user.MFAEnabled {
+		return apperr.Conflict("Two-factor authentication is already enabled")
+	}
+	if user.TOTPSecret == "" {
+		return apperr.Validation("Enroll before confirming")
+	}
+
+	var req models.MFACode
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+	valid, err := s.validTOTP(user, req.Code)
+	if err != nil {
+		return apperr.Internal("Failed to check code", err)
+	}
+	if !valid {
+		return apperr.Validation("Invalid code")
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return apperr.Internal("Failed to generate recovery codes", err)
+	}
+	if err := s.Users.EnableMFA(context.Background(), user.ID.Hex(), hashes); err != nil {
+		return apperr.Internal("Failed to enable two-factor authentication", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTOTPHandler turns off two-factor authentication. It takes a current
+// TOTP code or a recovery code.
+func (s *AuthService) DisableTOTPHandler(c *fiber.Ctx) error {
+	user, err := s.currentUser(c)
+	if err != nil {
+		return err
+	}
+	if !user.MFAEnabled {
+		return apperr.Validation("Two-factor authentication is not enabled")
+	}
+
+	var req models.MFACode
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+	valid, err := s.checkSecondFactor(user, req.Code)
+	if err != nil {
+		return apperr.Internal("Failed to check code", err)
+	}
+	if !valid {
+		return apperr.Unauthorized("Invalid code")
+	}
+
+	if err := s.Users.DisableMFA(context.Background(), user.ID.Hex()); err != nil {
+		return apperr.Internal("Failed to disable two-factor authentication", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Two-factor authentication disabled"})
+}
+
+// mfaChallenge answers a correct password for an account with two-factor
+// authentication. The MFA token proves the password was right and is
+// exchanged, together with a code, at /login/mfa.
+func (s *AuthService) mfaChallenge(c *fiber.Ctx, user *models.User) error {
+	token, err := s.Tokens.IssueActionToken(tokens.PurposeMFALogin, user.ID.Hex(), user.TOTPSecret, config.MFA_CHALLENGE_TTL)
+	if err != nil {
+		return apperr.Internal("Failed to create token", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"mfa_required": true,
+		"mfa_token":    token,
+		"expires_in":   int(config.MFA_CHALLENGE_TTL.Seconds()),
+	})
+}
+
+// MFALoginHandler completes a login with a TOTP code or a recovery code.
+func (s *AuthService) MFALoginHandler(c *fiber.Ctx) error {
+	var req models.MFALogin
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	claims, err := s.Tokens.ParseActionToken(tokens.PurposeMFALogin, req.MFAToken)
+	if err != nil {
+		return apperr.Unauthorized("Invalid or expired MFA token")
+	}
+
+	// Each MFA token allows a few guesses, so codes cannot be brute forced
+	attempts, err := s.Limiter.Allow(ctx, "mfa:attempts:"+claims.TokenID,
+		ratelimit.Rate{Limit: maxMFAAttempts, Window: config.MFA_CHALLENGE_TTL})
+	if err != nil {
+		return apperr.Internal("Failed to check code", err)
+	}
+	if !attempts.Allowed {
+		return apperr.Unauthorized("Too many attempts, please log in again")
+	}
+
+	user, err := s.Users.GetByID(ctx, claims.UserID)
+	if err == repository.ErrNotFound {
+		return apperr.Unauthorized("Invalid or expired MFA token")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to look up user", err)
+	}
+	if !user.MFAEnabled || !claims.Bound(user.TOTPSecret) {
+		return apperr.Unauthorized("Invalid or expired MFA token")
+	}
+	if user.Suspended {
+		return apperr.Forbidden("Account has been suspended")
+	}
+
+	// Wrong codes count towards the same lockout as wrong passwords, so
+	// logging in again for a fresh MFA token does not allow more guesses
+	lockoutKey := loginLockoutKey(user.Email)
+	lockedUntil, err := s.Limiter.LockedUntil(ctx, lockoutKey)
+	if err != nil {
+		return apperr.Internal("Failed to check account lockout", err)
+	}
+	if !lockedUntil.IsZero() {
+		return lockedError(lockedUntil)
+	}
+
+	valid, err := s.checkSecondFactor(user, req.Code)
+	if err != nil {
+		return apperr.Internal("Failed to check code", err)
+	}
+	if !valid {
+		lockedUntil, err := s.Limiter.Fail(ctx, lockoutKey, loginLockout())
+		if err != nil {
+			return apperr.Internal("Failed to record failed login", err)
+		}
+		if !lockedUntil.IsZero() {
+			return lockedError(lockedUntil)
+		}
+		return apperr.Unauthorized("Invalid code")
+	}
+	if err := s.Limiter.Reset(ctx, lockoutKey); err != nil {
+		return apperr.Internal("Failed to reset failed logins", err)
+	}
+	if err := s.Tokens.Consume(ctx, claims); err != nil {
+		if err == tokens.ErrTokenUsed || err == tokens.ErrInvalidToken {
+			return apperr.Unauthorized("Invalid or expired MFA token")
+		}
+		return apperr.Internal("Failed to use token", err)
+	}
+
+	pair, err := s.startSession(c, user)
+	if err != nil {
+		return apperr.Internal("Failed to create token", err)
+	}
+	pair["message"] = "Login successful!"
+
+	return c.Status(fiber.StatusOK).JSON(pair)
+}
+
+// checkSecondFactor accepts either a TOTP code or one of the user's recovery
+// codes, which is used up.
+func (s *AuthService) checkSecondFactor(user *models.User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if len(code) == int(totpOpts.Digits) {
+		return s.validTOTP(user, code)
+	}
+	return s.Users.UseRecoveryCode(context.Background(), user.ID.Hex(), tokens.HashOpaqueToken(normalizeRecoveryCode(code)))
+}
+
+// validTOTP checks code against the user's secret. A code is only accepted
+// once, so one seen over the user's shoulder cannot be replayed.
+func (s *AuthService) validTOTP(user *models.User, code string) (bool, error) {
+	valid, err := totp.ValidateCustom(code, user.TOTPSecret, time.Now(), totpOpts)
+	if err != nil || !valid {
+		return false, nil
+	}
+
+	// Codes are accepted for up to one period either side of now
+	window := time.Duration(totpOpts.Period*uint(2*totpOpts.Skew+1)) * time.Second
+	return s.Cache.SetNX(context.Background(), fmt.Sprintf("auth:totp:%s:%s", user.ID.Hex(), code), "1", window)
+}
+
+// newRecoveryCodes returns fresh recovery codes formatted for the user, and
+// the hashes to store.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := base32.StdEncoding.EncodeToString(b)[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, tokens.HashOpaqueToken(code))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
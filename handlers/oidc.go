@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"trademarkia/apperr"
+	"trademarkia/cache"
+	"trademarkia/config"
+	"trademarkia/identity"
+	"trademarkia/models"
+	"trademarkia/repository"
+	"trademarkia/tokens"
+	"trademarkia/validation"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// oidcStateCookie ties a sign in to the browser that started it, so nobody
+// can make a victim's browser finish a sign in of theirs
+const oidcStateCookie = "oidc_state"
+
+// oidcLogin is what is remembered about a sign in while the user is at the
+// provider.
+type oidcLogin struct {
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+func oidcLoginKey(state string) string {
+	return "auth:oidc:state:" + state
+}
+
+// OIDCLoginHandler sends the user to the identity provider to sign in.
+func (s *AuthService) OIDCLoginHandler(c *fiber.Ctx) error {
+	state, err := tokens.NewOpaqueToken()
+	if err != nil {
+		return apperr.Internal("Failed to start sign in", err)
+	}
+	nonce, err := tokens.NewOpaqueToken()
+	if err != nil {
+		return apperr.Internal("Failed to start sign in", err)
+	}
+	login := oidcLogin{Nonce: nonce, Verifier: identity.NewVerifier()}
+
+	value, _ := json.Marshal(login)
+	if err := s.Cache.Set(context.Background(), oidcLoginKey(state), string(value), config.OIDC_LOGIN_TTL); err != nil {
+		return apperr.Internal("Failed to start sign in", err)
+	}
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/auth/oidc",
+		MaxAge:   int(config.OIDC_LOGIN_TTL.Seconds()),
+		Secure:   strings.HasPrefix(config.PUBLIC_URL, "https://"),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+
+	return c.Redirect(s.Identity.AuthCodeURL(state, login.Nonce, login.Verifier), fiber.StatusFound)
+}
+
+// OIDCCallbackHandler finishes a sign in at the identity provider and logs
+// the user in, linking or creating their account on the first sign in.
+func (s *AuthService) OIDCCallbackHandler(c *fiber.Ctx) error {
+	if reason := c.Query("error"); reason != "" {
+		return apperr.Unauthorized("Sign in was not completed: " + reason)
+	}
+
+	state := c.Query("state")
+	if state == "" || state != c.Cookies(oidcStateCookie) {
+		return apperr.Unauthorized("Invalid sign in state")
+	}
+	c.Cookie(&fiber.Cookie{Name: oidcStateCookie, Path: "/auth/oidc", Expires: time.Unix(0, 0), HTTPOnly: true})
+
+	// The state can only be used once
+	ctx := context.Background()
+	value, err := s.Cache.Get(ctx, oidcLoginKey(state))
+	if err == cache.ErrMiss {
+		return apperr.Unauthorized("Sign in has expired, please try again")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to finish sign in", err)
+	}
+	if err := s.Cache.Del(ctx, oidcLoginKey(state)); err != nil {
+		return apperr.Internal("Failed to finish sign in", err)
+	}
+	var login oidcLogin
+	if err := json.Unmarshal([]byte(value), &login); err != nil {
+		return apperr.Internal("Failed to finish sign in", err)
+	}
+
+	ident, err := s.Identity.Exchange(ctx, c.Query("code"), login.Verifier, login.Nonce)
+	if errors.Is(err, identity.ErrInvalidLogin) {
+		return apperr.Unauthorized("Sign in failed")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to finish sign in", err)
+	}
+
+	user, err := s.userForIdentity(ctx, ident)
+	if err != nil {
+		return err
+	}
+	if user.Suspended {
+		return apperr.Forbidden("Account has been suspended")
+	}
+	if user.MFAEnabled {
+		return s.mfaChallenge(c, user)
+	}
+
+	pair, err := s.startSession(c, user)
+	if err != nil {
+		return apperr.Internal("Failed to create token", err)
+	}
+	pair["message"] = "Login successful!"
+
+	return c.Status(fiber.StatusOK).JSON(pair)
+}
+
+// userForIdentity finds the user ident belongs to. On the first sign in it is
+// linked to the account with the same email address, or a new account is
+// created for it.
+func (s *AuthService) userForIdentity(ctx context.Context, ident *identity.Identity) (*models.User, error) {
+	link := models.Identity{Issuer: ident.Issuer, Subject: ident.Subject}
+	user, err := s.Users.GetByIdentity(ctx, link)
+	if err == nil {
+		return user, nil
+	}
+	if err != repository.ErrNotFound {
+		return nil, apperr.Internal("Failed to look up user", err)
+	}
+
+	if ident.Email == "" || !ident.EmailVerified {
+		return nil, apperr.Forbidden("Your identity provider has not verified your email address")
+	}
+
+	email := validation.NormalizeEmail(ident.Email)
+	user, err = s.Users.GetByEmail(ctx, email)
+	if err == repository.ErrNotFound {
+		return s.createIdentityUser(ctx, ident, email, link)
+	}
+	if err != nil {
+		return nil, apperr.Internal("Failed to look up user", err)
+	}
+
+	userID := user.ID.Hex()
+	if !user.EmailVerified {
+		// The account was registered with the address but never proved it
+		// owned it, so it may not belong to this user. Whoever set its
+		// password is logged out and can no longer use it.
+		if err := s.Users.UpdatePassword(ctx, userID, ""); err != nil {
+			return nil, apperr.Internal("Failed to link account", err)
+		}
+		if err := s.revokeUserSessions(userID); err != nil {
+			return nil, apperr.Internal("Failed to link account", err)
+		}
+		if err := s.Users.MarkEmailVerified(ctx, userID, user.Email); err != nil {
+			return nil, apperr.Internal("Failed to link account", err)
+		}
+	}
+	if err := s.Users.LinkIdentity(ctx, userID, link); err != nil {
+		return nil, apperr.Internal("Failed to link account", err)
+	}
+	return user, nil
+}
+
+// createIdentityUser creates an account for someone signing in for the first
+// time with the normalised email. It has no password; one can be set with a
+// password reset.
+func (s *AuthService) createIdentityUser(ctx context.Context, ident *identity.Identity, email string, link models.Identity) (*models.User, error) {
+	username, err := s.freeUsername(ctx, ident)
+	if err != nil {
+		return nil, apperr.Internal("Failed to create account", err)
+	}
+
+	user := &models.User{
+		Email:         email,
+		Username:      username,
+		EmailVerified: true,
+		Role:          models.RoleUser,
+		Identities:    []models.Identity{link},
+	}
+	err = s.Users.Create(ctx, user)
+	if err == repository.ErrDuplicate {
+		return nil, apperr.Conflict("User with this email or username already exists")
+	}
+	if err != nil {
+		return nil, apperr.Internal("Failed to create account", err)
+	}
+	return user, nil
+}
+
+var usernameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// Usernames are as long as sign up allows.
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 32
+)
+
+// freeUsername picks an unused username based on the user's name at the
+// provider or their email address. Short names are padded and long ones cut
+// short, leaving room for the suffix that tells taken names apart.
+func (s *AuthService) freeUsername(ctx context.Context, ident *identity.Identity) (string, error) {
+	base := ident.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(ident.Email, "@")
+	}
+	base = usernameUnsafe.ReplaceAllString(base, "")
+	if len(base) < minUsernameLength {
+		base = strings.TrimSuffix("user-"+base, "-")
+	}
+	withSuffix := func(suffix string) string {
+		if len(base)+len(suffix) > maxUsernameLength {
+			return base[:maxUsernameLength-len(suffix)] + suffix
+		}
+		return base + suffix
+	}
+
+	for i := 1; i <= 100; i++ {
+		username := withSuffix("")
+		if i > 1 {
+			username = withSuffix(strconv.Itoa(i))
+		}
+		taken, err := s.Users.UsernameExists(ctx, username)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return username, nil
+		}
+	}
+	return withSuffix("-" + uuid.New().String()[:8]), nil
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"trademarkia/apperr"
+	"trademarkia/models"
+	"trademarkia/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Permission is the level of access a user has to a file. Each level includes
+// the ones below it.
+type Permission int
+
+const (
+	PermissionNone Permission = iota
+	PermissionRead
+	PermissionEdit
+	PermissionOwner
+)
+
+func (p Permission) String() string {
+	switch p {
+	case PermissionRead:
+		return "read"
+	case PermissionEdit:
+		return "edit"
+	case PermissionOwner:
+		return "owner"
+	default:
+		return "none"
+	}
+}
+
+func parsePermission(s string) Permission {
+	switch s {
+	case "read":
+		return PermissionRead
+	case "edit":
+		return PermissionEdit
+	case "owner":
+		return PermissionOwner
+	default:
+		return PermissionNone
+	}
+}
+
+// RequireFilePermission loads the file named by the file_id route parameter
+// and rejects the request unless the caller has at least the required
+// permission on it. Every route that acts on a single file goes through here.
+// Files in the trash are treated as missing. The file is stored in the "file"
+// local for the handler.
+func (s *FileService) RequireFilePermission(required Permission) fiber.Handler {
+	return s.requireFile(required, false)
+}
+
+// RequireTrashedFile is the counterpart of RequireFilePermission for routes
+// that act on files in the owner's trash.
+func (s *FileService) RequireTrashedFile() fiber.Handler {
+	return s.requireFile(PermissionOwner, true)
+}
+
+func (s *FileService) requireFile(required Permission, trashed bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, ok := c.Locals("userID").(string)
+		if !ok || userID == "" {
+			return apperr.Unauthorized("Unauthorized")
+		}
+
+		file, err := s.Files.Get(context.Background(), c.Params("file_id"), userID)
+		if err != nil && err != repository.ErrNotFound {
+			return apperr.Internal("Database query error", err)
+		}
+
+		// Files the caller cannot see at all are reported as missing so their
+		// existence is not leaked
+		permission := PermissionNone
+		if file != nil {
+			permission = parsePermission(file.Permission)
+		}
+		if permission == PermissionNone || (file.DeletedAt != nil) != trashed {
+			return apperr.NotFound("File not found")
+		}
+		if permission < required {
+			return apperr.Forbidden("You do not have " + required.String() + " access to this file")
+		}
+
+		c.Locals("file", file)
+		return c.Next()
+	}
+}
+
+// GrantPermissionHandler gives another registered user read or edit access to
+// a file. Granting to a user who already has access replaces their permission.
+func (s *FileService) GrantPermissionHandler(c *fiber.Ctx) error {
+	file := c.Locals("file").(*models.File)
+
+	var req models.GrantPermission
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+	permission := parsePermission(req.Permission)
+
+	grantee, err := s.Users.GetByLogin(context.Background(), req.User)
+	if err == repository.ErrNotFound {
+		return apperr.NotFound("User not found")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to look up user", err)
+	}
+	granteeID := grantee.ID.Hex()
+	if granteeID == file.UserID {
+		return apperr.Validation("The owner already has full access")
+	}
+
+	grant := &models.FileGrant{
+		FileID:     file.FileID,
+		UserID:     granteeID,
+		Permission: permission.String(),
+		GrantedBy:  file.UserID,
+		Username:   grantee.Username,
+	}
+	if err := s.Permissions.Grant(context.Background(), grant); err != nil {
+		return apperr.Internal("Failed to grant permission", err)
+	}
+
+	if err := s.bumpSearchCacheGeneration(granteeID); err != nil {
+		log.Println("Error invalidating cache:", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(grant)
+}
+
+func (s *FileService) ListPermissionsHandler(c *fiber.Ctx) error {
+	file := c.Locals("file").(*models.File)
+
+	grants, err := s.Permissions.List(context.Background(), file.FileID)
+	if err != nil {
+		return apperr.Internal("Database query error", err)
+	}
+
+	userIDs := make([]string, 0, len(grants))
+	for _, grant := range grants {
+		userIDs = append(userIDs, grant.UserID)
+	}
+	usernames, err := s.Users.Usernames(context.Background(), userIDs)
+	if err != nil {
+		return apperr.Internal("Failed to look up users", err)
+	}
+	for i := range grants {
+		grants[i].Username = usernames[grants[i].UserID]
+	}
+
+	return c.Status(fiber.StatusOK).JSON(grants)
+}
+
+func (s *FileService) RevokePermissionHandler(c *fiber.Ctx) error {
+	file := c.Locals("file").(*models.File)
+	userID := c.Params("user_id")
+
+	err := s.Permissions.Revoke(context.Background(), file.FileID, userID)
+	if err == repository.ErrNotFound {
+		return apperr.NotFound("Permission not found")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to revoke permission", err)
+	}
+
+	if err := s.bumpSearchCacheGeneration(userID); err != nil {
+		log.Println("Error invalidating cache:", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Permission revoked successfully"})
+}
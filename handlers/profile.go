@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"trademarkia/apperr"
+	"trademarkia/config"
+	"trademarkia/mail"
+	"trademarkia/models"
+	"trademarkia/repository"
+	"trademarkia/tokens"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// GetMeHandler returns the current user's account.
+func (s *AuthService) GetMeHandler(c *fiber.Ctx) error {
+	user, err := s.currentUser(c)
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusOK).JSON(user)
+}
+
+// UpdateMeHandler changes the current user's username and display settings.
+// Fields left out of the body are not changed.
+func (s *AuthService) UpdateMeHandler(c *fiber.Ctx) error {
+	user, err := s.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	var req models.ProfileRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+	if req.Username == nil && req.DisplayName == nil && req.Timezone == nil {
+		return apperr.Validation("Nothing to update")
+	}
+
+	ctx := context.Background()
+	if req.Username != nil && *req.Username != user.Username {
+		taken, err := s.Users.UsernameExists(ctx, *req.Username)
+		if err != nil {
+			return apperr.Internal("Failed to look up user", err)
+		}
+		if taken {
+			return apperr.Conflict("User with this username already exists")
+		}
+	}
+	if req.DisplayName != nil {
+		name := strings.TrimSpace(*req.DisplayName)
+		req.DisplayName = &name
+	}
+
+	err = s.Users.UpdateProfile(ctx, user.ID.Hex(), repository.ProfileUpdate{
+		Username:    req.Username,
+		DisplayName: req.DisplayName,
+		Timezone:    req.Timezone,
+	})
+	if err == repository.ErrDuplicate {
+		return apperr.Conflict("User with this username already exists")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to update account", err)
+	}
+
+	updated, err := s.Users.GetByID(ctx, user.ID.Hex())
+	if err != nil {
+		return apperr.Internal("Failed to look up user", err)
+	}
+	return c.Status(fiber.StatusOK).JSON(updated)
+}
+
+// confirmPassword checks password against the user's current one. Accounts
+// created through single sign-on have no password, so they are not asked for
+// one.
+func confirmPassword(user *models.User, password string) error {
+	if user.Password == "" {
+		return nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return apperr.Forbidden("Incorrect password")
+	}
+	return nil
+}
+
+// ChangePasswordHandler sets a new password after checking the current one.
+// Every other session is logged out; the one the request came from stays.
+func (s *AuthService) ChangePasswordHandler(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*tokens.Claims)
+	if !ok {
+		return apperr.Unauthorized("Unauthorized")
+	}
+	user, err := s.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	var req models.ChangePassword
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+	if err := confirmPassword(user, req.CurrentPassword); err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return apperr.Internal("Failed to encrypt password", err)
+	}
+	if err := s.Users.UpdatePassword(context.Background(), user.ID.Hex(), string(hashedPassword)); err != nil {
+		return apperr.Internal("Failed to update password", err)
+	}
+	if err := s.revokeOtherSessions(user.ID.Hex(), claims.FamilyID); err != nil {
+		return apperr.Internal("Failed to revoke sessions", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Password has been changed"})
+}
+
+// ChangeEmailHandler starts moving the account to a new email address. The
+// address only changes once the link mailed to it is opened; the current
+// address is told about the request.
+func (s *AuthService) ChangeEmailHandler(c *fiber.Ctx) error {
+	user, err := s.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	var req models.ChangeEmail
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+	email := req.Email
+	if err := confirmPassword(user, req.Password); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if email != user.Email {
+		taken, err := s.Users.EmailExists(ctx, email)
+		if err != nil {
+			return apperr.Internal("Failed to look up user", err)
+		}
+		if taken {
+			return apperr.Conflict("User with this email already exists")
+		}
+	}
+	if err := s.Users.SetPendingEmail(ctx, user.ID.Hex(), email); err != nil {
+		return apperr.Internal("Failed to update account", err)
+	}
+
+	// The link is bound to the new address, so asking for another change voids it
+	token, err := s.Tokens.IssueActionToken(tokens.PurposeChangeEmail, user.ID.Hex(), email, config.EMAIL_VERIFICATION_TTL)
+	if err != nil {
+		return apperr.Internal("Failed to create confirmation token", err)
+	}
+	err = s.Mailer.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm this is your new email address by opening this link:\n\n%s\n\nThe link expires in %s.\n",
+			user.Username, config.PUBLIC_URL+"/confirm-email?token="+url.QueryEscape(token), config.EMAIL_VERIFICATION_TTL),
+	})
+	if err != nil {
+		return apperr.Internal("Failed to send confirmation email", err)
+	}
+	err = s.Mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Your email address is being changed",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to change the email address of your account to %s. If it was not you, change your password straight away.\n",
+			user.Username, email),
+	})
+	if err != nil {
+		log.Println("Failed to send email change notice:", err)
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "Check your new email address to confirm the change",
+	})
+}
+
+// ConfirmEmailHandler moves the account to the new email address it was
+// asked to change to. Like VerifyEmailHandler, it takes the token from the
+// token query parameter or the request body.
+func (s *AuthService) ConfirmEmailHandler(c *fiber.Ctx) error {
+	token := c.Query("token")
+	if token == "" {
+		var req models.TokenRequest
+		if err := parseBody(c, &req); err != nil {
+			return err
+		}
+		token = req.Token
+	}
+
+	ctx := context.Background()
+	claims, err := s.Tokens.ParseActionToken(tokens.PurposeChangeEmail, token)
+	if err != nil {
+		return apperr.Validation("Invalid or expired confirmation link")
+	}
+	user, err := s.Users.GetByID(ctx, claims.UserID)
+	if err == repository.ErrNotFound {
+		return apperr.Validation("Invalid or expired confirmation link")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to look up user", err)
+	}
+	if user.PendingEmail == "" || !claims.Bound(user.PendingEmail) {
+		return apperr.Validation("Invalid or expired confirmation link")
+	}
+
+	// Someone may have signed up with the address since it was asked for
+	taken, err := s.Users.EmailExists(ctx, user.PendingEmail)
+	if err != nil {
+		return apperr.Internal("Failed to look up user", err)
+	}
+	if taken && user.PendingEmail != user.Email {
+		return apperr.Conflict("User with this email already exists")
+	}
+
+	if err := s.Tokens.Consume(ctx, claims); err != nil {
+		return consumeError(err)
+	}
+	err = s.Users.ChangeEmail(ctx, user.ID.Hex(), user.PendingEmail)
+	if err == repository.ErrDuplicate {
+		return apperr.Conflict("User with this email already exists")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to change email address", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Email address changed"})
+}
+
+// DeleteMeHandler deletes the current user's account. The user is logged
+// out everywhere and can no longer sign in straight away; their files and
+// the account itself are purged by a background job.
+func (s *AuthService) DeleteMeHandler(c *fiber.Ctx) error {
+	user, err := s.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	var req models.PasswordConfirmation
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+	if err := confirmPassword(user, req.Password); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	userID := user.ID.Hex()
+	if err := s.Users.MarkDeleted(ctx, userID); err != nil {
+		return apperr.Internal("Failed to delete account", err)
+	}
+	if err := s.cacheSuspended(ctx, userID, true); err != nil {
+		log.Println("Failed to update suspension cache:", err)
+	}
+	if err := s.revokeUserSessions(userID); err != nil {
+		log.Println("Failed to revoke sessions:", err)
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "Your account is being deleted"})
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"time"
+	"trademarkia/cache"
+	"trademarkia/identity"
+	"trademarkia/mail"
+	"trademarkia/models"
+	"trademarkia/ratelimit"
+	"trademarkia/repository"
+	"trademarkia/storage"
+	"trademarkia/tokens"
+)
+
+// The stores below are the parts of the repositories the handlers use. The
+// repository package implements them on Postgres and MongoDB; tests can pass
+// in-memory fakes instead.
+
+type FileStore interface {
+	Create(ctx context.Context, file *models.File) error
+	Get(ctx context.Context, fileID, userID string) (*models.File, error)
+	ListByUser(ctx context.Context, userID string, shared bool) ([]models.File, error)
+	Search(ctx context.Context, params repository.SearchParams) ([]models.File, error)
+	ListTrashed(ctx context.Context, userID string) ([]models.File, error)
+	Update(ctx context.Context, fileID string, update repository.FileUpdate, expectedVersion *int) (*models.File, error)
+	Trash(ctx context.Context, fileID string) (time.Time, error)
+	Restore(ctx context.Context, fileID string) error
+	Delete(ctx context.Context, fileID string) error
+	Usage(ctx context.Context, userID string) (*repository.Usage, error)
+}
+
+type ShareStore interface {
+	CreateLink(ctx context.Context, link *models.ShareLink) error
+	ListActiveLinks(ctx context.Context, fileID string) ([]models.ShareLink, error)
+	CreateShare(ctx context.Context, share *models.Share) error
+	ListShares(ctx context.Context, fileID string) ([]models.Share, error)
+	GetShareByTokenHash(ctx context.Context, tokenHash string) (*models.Share, error)
+	RevokeShare(ctx context.Context, fileID, shareID string) error
+	RecordShareAccess(ctx context.Context, shareID string) (bool, error)
+}
+
+type PermissionStore interface {
+	Grant(ctx context.Context, grant *models.FileGrant) error
+	List(ctx context.Context, fileID string) ([]models.FileGrant, error)
+	Revoke(ctx context.Context, fileID, userID string) error
+}
+
+type UserStore interface {
+	Create(ctx context.Context, user *models.User) error
+	GetByID(ctx context.Context, userID string) (*models.User, error)
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	GetByLogin(ctx context.Context, login string) (*models.User, error)
+	EmailExists(ctx context.Context, email string) (bool, error)
+	UsernameExists(ctx context.Context, username string) (bool, error)
+	MarkEmailVerified(ctx context.Context, userID, email string) error
+	UpdatePassword(ctx context.Context, userID, passwordHash string) error
+	SetTOTPSecret(ctx context.Context, userID, secret string) error
+	EnableMFA(ctx context.Context, userID string, recoveryCodeHashes []string) error
+	DisableMFA(ctx context.Context, userID string) error
+	UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error)
+	Usernames(ctx context.Context, userIDs []string) (map[string]string, error)
+	List(ctx context.Context, limit, offset int) ([]models.User, error)
+	SetRole(ctx context.Context, userID, role string) error
+	SetSuspended(ctx context.Context, userID string, suspended bool) error
+	GetByIdentity(ctx context.Context, identity models.Identity) (*models.User, error)
+	LinkIdentity(ctx context.Context, userID string, identity models.Identity) error
+	UpdateProfile(ctx context.Context, userID string, update repository.ProfileUpdate) error
+	SetPendingEmail(ctx context.Context, userID, email string) error
+	ChangeEmail(ctx context.Context, userID, email string) error
+	MarkDeleted(ctx context.Context, userID string) error
+}
+
+type RefreshTokenStore interface {
+	Create(ctx context.Context, token *models.RefreshToken) error
+	GetByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	MarkUsed(ctx context.Context, tokenID string) (bool, error)
+	RevokeFamily(ctx context.Context, familyID string) error
+	RevokeUser(ctx context.Context, userID, keepFamilyID string) ([]string, error)
+}
+
+type APIKeyStore interface {
+	Create(ctx context.Context, key *models.APIKey) error
+	GetByHash(ctx context.Context, keyHash string) (*models.APIKey, error)
+	ListByUser(ctx context.Context, userID string) ([]models.APIKey, error)
+	Delete(ctx context.Context, userID, keyID string) error
+}
+
+type SessionStore interface {
+	Create(ctx context.Context, session *models.Session) error
+	Get(ctx context.Context, sessionID string) (*models.Session, error)
+	ListActive(ctx context.Context, userID string) ([]models.Session, error)
+	Touch(ctx context.Context, sessionID, ip string, seenAt time.Time) error
+	Extend(ctx context.Context, sessionID string, expiresAt time.Time) error
+	Revoke(ctx context.Context, sessionID string) error
+}
+
+// AuthService handles sign up, login, the token lifecycle and account
+// recovery.
+type AuthService struct {
+	Users         UserStore
+	RefreshTokens RefreshTokenStore
+	APIKeys       APIKeyStore
+	Sessions      SessionStore
+	Tokens        *tokens.Manager
+	Mailer        mail.Mailer
+	Cache         cache.Cache
+	Limiter       ratelimit.Limiter
+	// Identity is the OpenID Connect provider users can sign in with, if any
+	Identity *identity.Provider
+
+	// RequireEmailVerification refuses logins to unverified accounts
+	RequireEmailVerification bool
+}
+
+// AdminService handles the admin API, on top of the other services.
+type AdminService struct {
+	Auth  *AuthService
+	Files *FileService
+}
+
+// FileService handles uploading, listing, sharing and downloading files.
+type FileService struct {
+	Files       FileStore
+	Shares      ShareStore
+	Permissions PermissionStore
+	Users       UserStore
+	Storage     storage.Storage
+	Cache       cache.Cache
+	Limiter     ratelimit.Limiter
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"time"
+	"trademarkia/apperr"
+	"trademarkia/cache"
+	"trademarkia/config"
+	"trademarkia/models"
+	"trademarkia/repository"
+	"trademarkia/tokens"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/google/uuid"
+)
+
+// sessionCacheTTL is how long whether a session is active is cached for.
+// Revoking a session updates the cache straight away; the entry expiring is
+// also what moves last_seen_at forward.
+const sessionCacheTTL = time.Minute
+
+// maxUserAgentLength bounds the user agent stored with a session.
+const maxUserAgentLength = 256
+
+func sessionCacheKey(sessionID string) string {
+	return "auth:session:" + sessionID
+}
+
+// startSession records a new login from the request and issues its first
+// tokens.
+func (s *AuthService) startSession(c *fiber.Ctx, user *models.User) (fiber.Map, error) {
+	now := time.Now()
+	session := &models.Session{
+		ID:         uuid.New().String(),
+		UserID:     user.ID.Hex(),
+		UserAgent:  userAgent(c),
+		IP:         c.IP(),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(config.REFRESH_TOKEN_TTL),
+	}
+	if err := s.Sessions.Create(context.Background(), session); err != nil {
+		return nil, err
+	}
+	return s.issueTokens(user, session.ID)
+}
+
+// continueSession keeps the session a refresh token belongs to alive.
+// Logins from before sessions were tracked get one on their next refresh.
+func (s *AuthService) continueSession(c *fiber.Ctx, token *models.RefreshToken) error {
+	ctx := context.Background()
+	now := time.Now()
+	session, err := s.Sessions.Get(ctx, token.FamilyID)
+	if err == repository.ErrNotFound {
+		err = s.Sessions.Create(ctx, &models.Session{
+			ID:         token.FamilyID,
+			UserID:     token.UserID,
+			UserAgent:  userAgent(c),
+			IP:         c.IP(),
+			CreatedAt:  token.CreatedAt,
+			LastSeenAt: now,
+			ExpiresAt:  now.Add(config.REFRESH_TOKEN_TTL),
+		})
+		if err != nil {
+			return apperr.Internal("Failed to create session", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return apperr.Internal("Failed to look up session", err)
+	}
+	if session.RevokedAt != nil {
+		return apperr.Unauthorized("Session has been revoked")
+	}
+
+	if err := s.Sessions.Touch(ctx, session.ID, c.IP(), now); err != nil {
+		return apperr.Internal("Failed to update session", err)
+	}
+	if err := s.Sessions.Extend(ctx, session.ID, now.Add(config.REFRESH_TOKEN_TTL)); err != nil {
+		return apperr.Internal("Failed to update session", err)
+	}
+	return nil
+}
+
+// userAgent returns a copy of the request's user agent that is safe to keep
+// after the request.
+func userAgent(c *fiber.Ctx) string {
+	userAgent := utils.CopyString(c.Get(fiber.HeaderUserAgent))
+	if len(userAgent) > maxUserAgentLength {
+		return userAgent[:maxUserAgentLength]
+	}
+	return userAgent
+}
+
+// SessionActive reports whether the session an access token belongs to has
+// not been revoked, and records that it was used from ip. It is checked on
+// every request made with an access token, so the answer is cached briefly.
+func (s *AuthService) SessionActive(ctx context.Context, sessionID, ip string) (bool, error) {
+	value, err := s.Cache.Get(ctx, sessionCacheKey(sessionID))
+	if err == nil {
+		return value == "1", nil
+	}
+	if err != cache.ErrMiss {
+		return false, err
+	}
+
+	active := false
+	session, err := s.Sessions.Get(ctx, sessionID)
+	if err == nil {
+		active = session.Active()
+	} else if err != repository.ErrNotFound {
+		return false, err
+	}
+	if active {
+		if err := s.Sessions.Touch(ctx, sessionID, ip, time.Now()); err != nil {
+			return false, err
+		}
+	}
+	return active, s.cacheSessionActive(ctx, sessionID, active)
+}
+
+func (s *AuthService) cacheSessionActive(ctx context.Context, sessionID string, active bool) error {
+	value := "0"
+	if active {
+		value = "1"
+	}
+	return s.Cache.Set(ctx, sessionCacheKey(sessionID), value, sessionCacheTTL)
+}
+
+// endSession revokes a session and every access token issued in it.
+func (s *AuthService) endSession(ctx context.Context, sessionID string) error {
+	if err := s.Sessions.Revoke(ctx, sessionID); err != nil {
+		return err
+	}
+	if err := s.Tokens.RevokeFamily(ctx, sessionID); err != nil {
+		return err
+	}
+	return s.cacheSessionActive(ctx, sessionID, false)
+}
+
+// ListSessionsHandler lists the user's active logins, marking the one the
+// request was made from.
+func (s *AuthService) ListSessionsHandler(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*tokens.Claims)
+	if !ok {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	sessions, err := s.Sessions.ListActive(context.Background(), claims.UserID)
+	if err != nil {
+		return apperr.Internal("Failed to list sessions", err)
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == claims.FamilyID
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"sessions": sessions})
+}
+
+// RevokeSessionHandler logs one of the user's sessions out.
+func (s *AuthService) RevokeSessionHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok || userID == "" {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	session, err := s.Sessions.Get(context.Background(), c.Params("session_id"))
+	if err == repository.ErrNotFound || (err == nil && (session.UserID != userID || !session.Active())) {
+		return apperr.NotFound("Session not found")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to look up session", err)
+	}
+
+	if err := s.revokeFamily(session.ID); err != nil {
+		return apperr.Internal("Failed to revoke session", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Session revoked"})
+}
+
+// LogoutAllHandler logs the user out everywhere, including the session the
+// request was made from.
+func (s *AuthService) LogoutAllHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok || userID == "" {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	if err := s.revokeUserSessions(userID); err != nil {
+		return apperr.Internal("Failed to revoke sessions", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Logged out of all sessions"})
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+	"trademarkia/apperr"
+	"trademarkia/config"
+	"trademarkia/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// ShareFileHandler mints a presigned download link for a file owned by the
+// caller. The lifetime is taken from ttl in the request body or the query,
+// either as a Go duration ("30m", "2h") or in seconds, and is capped at
+// SHARE_LINK_MAX_TTL.
+func (s *FileService) ShareFileHandler(c *fiber.Ctx) error {
+	file := c.Locals("file").(*models.File)
+
+	value := c.Query("ttl")
+	if len(c.Body()) > 0 {
+		var req models.ShareLinkRequest
+		if err := parseBody(c, &req); err != nil {
+			return err
+		}
+		if req.TTL != "" {
+			value = req.TTL
+		}
+	}
+	ttl, err := parseTTL(value)
+	if err != nil {
+		return apperr.Validation(err.Error())
+	}
+
+	shareURL, err := s.Storage.PresignGet(context.Background(), file.FileID, ttl)
+	if err != nil {
+		return apperr.Internal("Failed to create share link", err)
+	}
+
+	now := time.Now()
+	link := &models.ShareLink{
+		LinkID:    uuid.New().String(),
+		FileID:    file.FileID,
+		UserID:    file.UserID,
+		URL:       shareURL,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := s.Shares.CreateLink(context.Background(), link); err != nil {
+		return apperr.Internal("Failed to record share link", err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(link)
+}
+
+// ListShareLinksHandler returns the share links for a file that have not yet
+// expired.
+func (s *FileService) ListShareLinksHandler(c *fiber.Ctx) error {
+	file := c.Locals("file").(*models.File)
+
+	links, err := s.Shares.ListActiveLinks(context.Background(), file.FileID)
+	if err != nil {
+		return apperr.Internal("Database query error", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(links)
+}
+
+func parseTTL(value string) (time.Duration, error) {
+	if value == "" {
+		return min(config.SHARE_LINK_DEFAULT_TTL, config.SHARE_LINK_MAX_TTL), nil
+	}
+
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, convErr := strconv.Atoi(value)
+		if convErr != nil {
+			return 0, errors.New("ttl must be a duration such as 2h or a number of seconds")
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+	if ttl <= 0 {
+		return 0, errors.New("ttl must be positive")
+	}
+	return min(ttl, config.SHARE_LINK_MAX_TTL), nil
+}
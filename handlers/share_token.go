@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"time"
+	"trademarkia/apperr"
+	"trademarkia/config"
+	"trademarkia/models"
+	"trademarkia/ratelimit"
+	"trademarkia/repository"
+	"trademarkia/tokens"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// CreateShareHandler creates a revocable public share token for a file owned
+// by the caller. The token itself is only returned once; the database keeps a
+// SHA-256 hash of it.
+func (s *FileService) CreateShareHandler(c *fiber.Ctx) error {
+	file := c.Locals("file").(*models.File)
+
+	var req models.CreateShare
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresIn != "" {
+		ttl, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			return apperr.Validation("Invalid expires_in")
+		}
+		t := time.Now().Add(ttl)
+		expiresAt = &t
+	}
+
+	var passwordHash *string
+	if req.Password != "" {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return apperr.Internal("Failed to encrypt password", err)
+		}
+		h := string(hashed)
+		passwordHash = &h
+	}
+
+	token, err := tokens.NewOpaqueToken()
+	if err != nil {
+		return apperr.Internal("Failed to create share", err)
+	}
+
+	share := &models.Share{
+		ShareID:      uuid.New().String(),
+		TokenHash:    tokens.HashOpaqueToken(token),
+		FileID:       file.FileID,
+		UserID:       file.UserID,
+		PasswordHash: passwordHash,
+		Protected:    passwordHash != nil,
+		ExpiresAt:    expiresAt,
+		MaxDownloads: req.MaxDownloads,
+		CreatedAt:    time.Now(),
+	}
+	if err := s.Shares.CreateShare(context.Background(), share); err != nil {
+		return apperr.Internal("Failed to create share", err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"share_id":      share.ShareID,
+		"token":         token,
+		"url":           config.PUBLIC_URL + "/s/" + token,
+		"expires_at":    share.ExpiresAt,
+		"max_downloads": share.MaxDownloads,
+		"protected":     share.Protected,
+	})
+}
+
+// ListSharesHandler lists every share token created for a file, including
+// revoked and expired ones, with their download counts.
+func (s *FileService) ListSharesHandler(c *fiber.Ctx) error {
+	file := c.Locals("file").(*models.File)
+
+	shares, err := s.Shares.ListShares(context.Background(), file.FileID)
+	if err != nil {
+		return apperr.Internal("Database query error", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(shares)
+}
+
+func (s *FileService) RevokeShareHandler(c *fiber.Ctx) error {
+	file := c.Locals("file").(*models.File)
+
+	err := s.Shares.RevokeShare(context.Background(), file.FileID, c.Params("share_id"))
+	if err == repository.ErrNotFound {
+		return apperr.NotFound("Share not found")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to revoke share", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Share revoked successfully"})
+}
+
+// sharePasswordLockout locks a protected share after SHARE_PASSWORD_ATTEMPTS
+// wrong passwords in a row, with the same backoff as logins.
+func sharePasswordLockout() ratelimit.Lockout {
+	return ratelimit.Lockout{
+		Threshold: config.SHARE_PASSWORD_ATTEMPTS,
+		Base:      config.LOGIN_LOCKOUT_BASE,
+		Max:       config.LOGIN_LOCKOUT_MAX,
+		Memory:    24 * time.Hour,
+	}
+}
+
+// PublicShareHandler resolves a share token and streams the shared file. The
+// password of a protected share is read from the X-Share-Password header, so
+// it stays out of access logs. Only complete downloads are counted against the
+// share's download limit; conditional and range requests are not.
+func (s *FileService) PublicShareHandler(c *fiber.Ctx) error {
+	ctx := context.Background()
+	share, err := s.Shares.GetShareByTokenHash(ctx, tokens.HashOpaqueToken(c.Params("token")))
+	if err == repository.ErrNotFound {
+		return apperr.NotFound("Share not found")
+	}
+	if err != nil {
+		return apperr.Internal("Database query error", err)
+	}
+
+	if share.RevokedAt != nil || (share.ExpiresAt != nil && time.Now().After(*share.ExpiresAt)) ||
+		(share.MaxDownloads != nil && share.DownloadCount >= *share.MaxDownloads) {
+		return apperr.Gone("Share is no longer available")
+	}
+	if share.PasswordHash != nil {
+		if err := s.checkSharePassword(ctx, share, c.Get("X-Share-Password")); err != nil {
+			return err
+		}
+	}
+
+	if err := s.serveFile(c, share.FileID, share.Filename); err != nil {
+		return err
+	}
+	if c.Response().StatusCode() != fiber.StatusOK {
+		return nil
+	}
+
+	// The limit is checked again as the download is counted, in case another
+	// one used it up in the meantime. The error replaces the file.
+	counted, err := s.Shares.RecordShareAccess(ctx, share.ShareID)
+	if err != nil {
+		return apperr.Internal("Database update error", err)
+	}
+	if !counted {
+		return apperr.Gone("Share is no longer available")
+	}
+	return nil
+}
+
+// checkSharePassword checks the password of a protected share, locking the
+// share out after too many wrong ones.
+func (s *FileService) checkSharePassword(ctx context.Context, share *models.Share, password string) error {
+	lockoutKey := "share:" + share.ShareID
+	lockedUntil, err := s.Limiter.LockedUntil(ctx, lockoutKey)
+	if err != nil {
+		return apperr.Internal("Failed to check share lockout", err)
+	}
+	if !lockedUntil.IsZero() {
+		return shareLockedError(lockedUntil)
+	}
+
+	if password != "" && bcrypt.CompareHashAndPassword([]byte(*share.PasswordHash), []byte(password)) == nil {
+		if err := s.Limiter.Reset(ctx, lockoutKey); err != nil {
+			return apperr.Internal("Failed to reset share lockout", err)
+		}
+		return nil
+	}
+	if password == "" {
+		return apperr.Unauthorized("Invalid share password")
+	}
+
+	lockedUntil, err = s.Limiter.Fail(ctx, lockoutKey, sharePasswordLockout())
+	if err != nil {
+		return apperr.Internal("Failed to record wrong share password", err)
+	}
+	if !lockedUntil.IsZero() {
+		return shareLockedError(lockedUntil)
+	}
+	return apperr.Unauthorized("Invalid share password")
+}
+
+func shareLockedError(until time.Time) error {
+	e := apperr.TooManyRequests("Too many wrong passwords, the share is locked until "+until.UTC().Format(time.RFC3339), time.Until(until))
+	e.Details["locked_until"] = until.UTC()
+	return e
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"net/url"
+	"trademarkia/apperr"
+	"trademarkia/storage"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PresignedObjectHandler serves presigned download links for storage backends
+// that cannot hand out links of their own (local and memory).
+func (s *FileService) PresignedObjectHandler(c *fiber.Ctx) error {
+	verifier, ok := s.Storage.(storage.SignedURLVerifier)
+	if !ok {
+		return apperr.NotFound("Not found")
+	}
+
+	key, err := url.PathUnescape(c.Params("*"))
+	if err != nil {
+		return apperr.Validation("Invalid object key")
+	}
+	if err := verifier.VerifySignedURL(key, c.Query("expires"), c.Query("signature")); err != nil {
+		return apperr.Forbidden("Invalid or expired link")
+	}
+
+	return s.serveFile(c, key, key)
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"trademarkia/apperr"
+	"trademarkia/config"
+	"trademarkia/models"
+	"trademarkia/repository"
+	"trademarkia/tokens"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// issueTokens creates an access token and a refresh token for user in the
+// given family.
+func (s *AuthService) issueTokens(user *models.User, familyID string) (fiber.Map, error) {
+	userID := user.ID.Hex()
+	accessToken, _, err := s.Tokens.IssueAccessToken(userID, familyID, user.RoleName())
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := tokens.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	err = s.RefreshTokens.Create(context.Background(), &models.RefreshToken{
+		ID:        uuid.New().String(),
+		TokenHash: tokens.HashOpaqueToken(refreshToken),
+		UserID:    userID,
+		FamilyID:  familyID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(config.REFRESH_TOKEN_TTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return fiber.Map{
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(s.Tokens.AccessTTL().Seconds()),
+	}, nil
+}
+
+// RefreshTokenHandler exchanges a refresh token for a new access token and a
+// new refresh token. Refresh tokens can only be used once: presenting one
+// again means it has been stolen, so the whole family is revoked and the user
+// has to log in again.
+func (s *AuthService) RefreshTokenHandler(c *fiber.Ctx) error {
+	var req models.RefreshRequest
+	if err := parseBody(c, &req); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	token, err := s.RefreshTokens.GetByHash(ctx, tokens.HashOpaqueToken(req.RefreshToken))
+	if err == repository.ErrNotFound {
+		return apperr.Unauthorized("Invalid refresh token")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to look up refresh token", err)
+	}
+	if token.RevokedAt != nil {
+		return apperr.Unauthorized("Refresh token has been revoked")
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return apperr.Unauthorized("Refresh token has expired")
+	}
+
+	marked := false
+	if token.UsedAt == nil {
+		if marked, err = s.RefreshTokens.MarkUsed(ctx, token.ID); err != nil {
+			return apperr.Internal("Failed to use refresh token", err)
+		}
+	}
+	if !marked {
+		if err := s.revokeFamily(token.FamilyID); err != nil {
+			return apperr.Internal("Failed to revoke session", err)
+		}
+		return apperr.Unauthorized("Refresh token reuse detected, please log in again")
+	}
+
+	// The user is looked up again so role changes apply from the next refresh
+	user, err := s.Users.GetByID(ctx, token.UserID)
+	if err == repository.ErrNotFound {
+		return apperr.Unauthorized("User no longer exists")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to look up user", err)
+	}
+	if user.Suspended {
+		return apperr.Forbidden("Account has been suspended")
+	}
+
+	if err := s.continueSession(c, token); err != nil {
+		return err
+	}
+
+	pair, err := s.issueTokens(user, token.FamilyID)
+	if err != nil {
+		return apperr.Internal("Failed to create token", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(pair)
+}
+
+// LogoutHandler revokes the session the access token belongs to: the token
+// itself, the other access tokens of its family and its refresh tokens.
+func (s *AuthService) LogoutHandler(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*tokens.Claims)
+	if !ok {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	if err := s.revokeFamily(claims.FamilyID); err != nil {
+		return apperr.Internal("Failed to revoke session", err)
+	}
+	if err := s.Tokens.Revoke(context.Background(), claims); err != nil {
+		return apperr.Internal("Failed to revoke token", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Logged out successfully"})
+}
+
+func (s *AuthService) revokeFamily(familyID string) error {
+	if err := s.RefreshTokens.RevokeFamily(context.Background(), familyID); err != nil {
+		return err
+	}
+	return s.endSession(context.Background(), familyID)
+}
+
+// JWKSHandler publishes the public keys access tokens can be verified with,
+// so other services can check them without sharing a secret.
+func (s *AuthService) JWKSHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", int(tokens.JWKSMaxAge.Seconds())))
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"keys": s.Tokens.Keys().JWKS()})
+}
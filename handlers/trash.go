@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"trademarkia/apperr"
+	"trademarkia/config"
+	"trademarkia/models"
+	"trademarkia/repository"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DeleteFileHandler moves a file into its owner's trash. Trashed files are
+// hidden everywhere except /trash and are purged by the file deletion job once
+// TRASH_RETENTION has passed.
+func (s *FileService) DeleteFileHandler(c *fiber.Ctx) error {
+	file := c.Locals("file").(*models.File)
+
+	deletedAt, err := s.Files.Trash(context.Background(), file.FileID)
+	if err == repository.ErrNotFound {
+		return apperr.NotFound("File not found")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to delete file", err)
+	}
+
+	if err := s.invalidateFileSearchCache(file); err != nil {
+		log.Println("Error invalidating cache:", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":  "File moved to trash",
+		"purge_at": deletedAt.Add(config.TRASH_RETENTION),
+	})
+}
+
+func (s *FileService) GetTrashHandler(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(string)
+	if !ok || userID == "" {
+		return apperr.Unauthorized("Unauthorized")
+	}
+
+	files, err := s.Files.ListTrashed(context.Background(), userID)
+	if err != nil {
+		return apperr.Internal("Database query error", err)
+	}
+
+	trash := make([]fiber.Map, 0, len(files))
+	for _, file := range files {
+		trash = append(trash, fiber.Map{
+			"file_id":     file.FileID,
+			"filename":    file.Filename,
+			"upload_date": file.UploadDate,
+			"deleted_at":  file.DeletedAt,
+			"purge_at":    file.DeletedAt.Add(config.TRASH_RETENTION),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(trash)
+}
+
+func (s *FileService) RestoreFileHandler(c *fiber.Ctx) error {
+	file := c.Locals("file").(*models.File)
+
+	err := s.Files.Restore(context.Background(), file.FileID)
+	if err == repository.ErrNotFound {
+		return apperr.NotFound("File not found in trash")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to restore file", err)
+	}
+
+	if err := s.invalidateFileSearchCache(file); err != nil {
+		log.Println("Error invalidating cache:", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "File restored successfully"})
+}
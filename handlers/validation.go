@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"trademarkia/apperr"
+	"trademarkia/validation"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// normalizer is implemented by request bodies that tidy up their fields, like
+// email addresses, before they are validated.
+type normalizer interface {
+	Normalize()
+}
+
+// parseBody binds the request body to out and checks it against the validate
+// tags on its fields.
+func parseBody(c *fiber.Ctx, out interface{}) error {
+	if err := c.BodyParser(out); err != nil {
+		return apperr.Validation("Invalid request body")
+	}
+	if body, ok := out.(normalizer); ok {
+		body.Normalize()
+	}
+	return validation.Struct(out)
+}
@@ -0,0 +1,97 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+	"trademarkia/models"
+	"trademarkia/storage"
+)
+
+const (
+	purgeInterval = time.Minute
+	// Files are deleted this many at a time
+	purgeBatchSize = 100
+)
+
+// DeletedUserStore lists the accounts waiting to be purged and removes them.
+type DeletedUserStore interface {
+	ListDeleted(ctx context.Context) ([]models.User, error)
+	Purge(ctx context.Context, userID string) error
+}
+
+// OwnedFileStore lists and deletes a user's files.
+type OwnedFileStore interface {
+	ListOwned(ctx context.Context, userID string, limit int) ([]models.File, error)
+	Delete(ctx context.Context, fileID string) error
+}
+
+// UserDataStore holds other data about users, like their sessions.
+type UserDataStore interface {
+	DeleteUser(ctx context.Context, userID string) error
+}
+
+// AccountPurger permanently deletes the accounts users have deleted: every
+// stored object and file row they own, the rest of their data and finally the
+// user document. Each step can be repeated, so a purge that is interrupted is
+// finished on a later run.
+type AccountPurger struct {
+	Users    DeletedUserStore
+	Files    OwnedFileStore
+	UserData []UserDataStore
+	Storage  storage.Storage
+}
+
+func StartAccountPurgeJob(purger *AccountPurger) {
+	go func() {
+		for {
+			purger.Run(context.Background())
+			time.Sleep(purgeInterval)
+		}
+	}()
+}
+
+// Run purges every deleted account. An account that cannot be purged
+// completely is left for the next run.
+func (p *AccountPurger) Run(ctx context.Context) {
+	users, err := p.Users.ListDeleted(ctx)
+	if err != nil {
+		log.Println("Database Query Error:", err)
+		return
+	}
+
+	for _, user := range users {
+		if err := p.purge(ctx, user.ID.Hex()); err != nil {
+			log.Printf("Failed to purge account %s: %v", user.ID.Hex(), err)
+		}
+	}
+}
+
+func (p *AccountPurger) purge(ctx context.Context, userID string) error {
+	for {
+		files, err := p.Files.ListOwned(ctx, userID, purgeBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			break
+		}
+		// The object goes first: a row without an object is found again on
+		// the next run, an object without a row would be lost
+		for _, file := range files {
+			if err := p.Storage.Delete(ctx, file.FileID); err != nil {
+				return err
+			}
+			if err := p.Files.Delete(ctx, file.FileID); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, store := range p.UserData {
+		if err := store.DeleteUser(ctx, userID); err != nil {
+			return err
+		}
+	}
+	return p.Users.Purge(ctx, userID)
+}
@@ -2,82 +2,60 @@ package jobs
 
 import (
 	"context"
-	"database/sql"
-	"fmt"
 	"log"
-	"strings"
 	"time"
 	"trademarkia/config"
-
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"trademarkia/models"
+	"trademarkia/storage"
 )
 
 const (
 	deleteInterval = 24 * time.Hour
+	// Uploads are kept for this long before they are deleted
+	fileLifetime = 3 * 24 * time.Hour
 )
 
-func StartFileDeletionJob(db *sql.DB, s3Client *s3.Client) {
+// ExpiredFileStore lists the files due for permanent deletion and deletes
+// their rows.
+type ExpiredFileStore interface {
+	ListExpired(ctx context.Context, uploadedBefore, trashedBefore time.Time) ([]models.File, error)
+	Delete(ctx context.Context, fileID string) error
+}
+
+// FileDeleter permanently deletes live uploads older than fileLifetime and
+// files that have been in the trash for longer than TRASH_RETENTION. Trashed
+// files are only deleted once their retention has passed, at the purge_at
+// reported to their owner.
+type FileDeleter struct {
+	Files   ExpiredFileStore
+	Storage storage.Storage
+}
+
+func StartFileDeletionJob(deleter *FileDeleter) {
 	go func() {
 		for {
-			deleteExpiredFiles(db, s3Client)
+			deleter.Run(context.Background(), time.Now())
 			time.Sleep(deleteInterval)
 		}
 	}()
 }
 
-func deleteExpiredFiles(db *sql.DB, s3Client *s3.Client) {
-	ctx := context.Background()
-	rows, err := db.QueryContext(ctx, `SELECT file_id, s3_url FROM files WHERE upload_date < NOW() - INTERVAL '3 days'`)
+// Run deletes the files that have expired at now. A file whose object cannot
+// be deleted is left for the next run.
+func (d *FileDeleter) Run(ctx context.Context, now time.Time) {
+	expired, err := d.Files.ListExpired(ctx, now.Add(-fileLifetime), now.Add(-config.TRASH_RETENTION))
 	if err != nil {
 		log.Println("Database Query Error:", err)
 		return
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var fileID, s3URL string
-		if err := rows.Scan(&fileID, &s3URL); err != nil {
-			log.Println("Database Scan Error:", err)
-			return
-		}
-		_, err := s3Client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-			Bucket: aws.String(config.S3_BUCKET),
-			Key:    aws.String(fileID),
-		})
-		if err != nil {
-			log.Println("S3 Delete Error:", err)
+
+	for _, file := range expired {
+		if err := d.Storage.Delete(ctx, file.FileID); err != nil {
+			log.Println("Storage Delete Error:", err)
 			continue
 		}
-		_, err = db.ExecContext(ctx, `DELETE FROM files WHERE file_id = $1`, fileID)
-		if err != nil {
+		if err := d.Files.Delete(ctx, file.FileID); err != nil {
 			log.Println("Database Deletion Error:", err)
 		}
 	}
 }
-
-func deleteFileFromS3(s3Client *s3.Client, s3URL string) error {
-	// Extract the bucket and key from the s3URL
-	bucket, key := extractBucketAndKey(s3URL)
-
-	// Create the delete object input
-	input := &s3.DeleteObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	}
-
-	// Call S3 to delete the object
-	_, err := s3Client.DeleteObject(context.TODO(), input)
-	if err != nil {
-		return fmt.Errorf("failed to delete object from S3: %w", err)
-	}
-
-	return nil
-}
-
-func extractBucketAndKey(s3URL string) (string, string) {
-	parts := strings.Split(s3URL, "/")
-	bucket := parts[2]
-	key := parts[3]
-
-	return bucket, key
-}
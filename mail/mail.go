@@ -0,0 +1,32 @@
+// Package mail sends the emails the service needs, such as address
+// verification and password resets.
+package mail
+
+import (
+	"context"
+	"fmt"
+	"trademarkia/config"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns the Mailer selected by MAIL_BACKEND.
+func New() (Mailer, error) {
+	switch config.MAIL_BACKEND {
+	case "smtp":
+		return NewSMTPMailer(config.SMTP_HOST, config.SMTP_PORT, config.SMTP_USERNAME, config.SMTP_PASSWORD, config.MAIL_FROM), nil
+	case "log":
+		return NewLogMailer(config.MAIL_DIR, config.MAIL_FROM)
+	default:
+		return nil, fmt.Errorf("mail: unknown backend %q", config.MAIL_BACKEND)
+	}
+}
@@ -1,7 +1,22 @@
 package main
 
-import "trademarkia/server"
+import (
+	"os"
+	"trademarkia/server"
+)
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		server.Migrate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "role" {
+		server.SetRole(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "duplicates" {
+		server.Duplicates(os.Args[2:])
+		return
+	}
 	server.StartServer()
 }
@@ -0,0 +1,59 @@
+package middlewares
+
+import (
+	"context"
+	"strconv"
+	"trademarkia/apperr"
+	"trademarkia/ratelimit"
+	"trademarkia/validation"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RateLimit returns a middleware that allows rate requests for each key. The
+// name keeps the counters of different limits apart. Requests key returns
+// an empty string for are not limited.
+func RateLimit(limiter ratelimit.Limiter, name string, rate ratelimit.Rate, key func(*fiber.Ctx) string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		k := key(c)
+		if rate.Disabled() || k == "" {
+			return c.Next()
+		}
+
+		result, err := limiter.Allow(context.Background(), name+":"+k, rate)
+		if err != nil {
+			return apperr.Internal("Failed to check rate limit", err)
+		}
+		c.Set("X-RateLimit-Limit", strconv.Itoa(rate.Limit))
+		c.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		if !result.Allowed {
+			return apperr.TooManyRequests("Too many requests, please try again later", result.RetryAfter)
+		}
+
+		return c.Next()
+	}
+}
+
+// ByIP keys rate limits by the client's IP address
+func ByIP(c *fiber.Ctx) string {
+	return c.IP()
+}
+
+// ByUser keys rate limits by the authenticated user, so it has to run after
+// AuthMiddleware
+func ByUser(c *fiber.Ctx) string {
+	userID, _ := c.Locals("userID").(string)
+	return userID
+}
+
+// ByEmail keys rate limits by the email address in the request body. The body
+// is read with the same parser as the handlers, whatever its content type.
+func ByEmail(c *fiber.Ctx) string {
+	var body struct {
+		Email string `json:"email" xml:"email" form:"email"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return ""
+	}
+	return validation.NormalizeEmail(body.Email)
+}
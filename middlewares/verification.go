@@ -1,68 +1,154 @@
 package middlewares
 
 import (
+	"context"
 	"fmt"
 	"strings"
-	"trademarkia/config"
+	"trademarkia/apperr"
+	"trademarkia/models"
+	"trademarkia/repository"
+	"trademarkia/tokens"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gofiber/fiber/v2"
 )
 
-// AuthMiddleware is a middleware that verifies JWT tokens
-func AuthMiddleware(c *fiber.Ctx) error {
-	// Extract token from the Authorization header
-	tokenHeader := c.Get("Authorization")
-	if tokenHeader == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "No token provided",
-		})
-	}
+// APIKeyStore looks up API keys by the hash of the key.
+type APIKeyStore interface {
+	GetByHash(ctx context.Context, keyHash string) (*models.APIKey, error)
+}
 
-	// Split the token header to get the token part
-	tokenParts := strings.Split(tokenHeader, "Bearer ")
-	if len(tokenParts) != 2 {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Invalid token format",
-		})
-	}
-	tokenString := tokenParts[1]
+// AccountStatus reports whether an account has been suspended and whether
+// the session an access token belongs to is still active.
+type AccountStatus interface {
+	Suspended(ctx context.Context, userID string) (bool, error)
+	SessionActive(ctx context.Context, sessionID, ip string) (bool, error)
+}
+
+// AuthMiddleware returns a middleware that authenticates requests with either
+// an access token or an API key, and rejects revoked or expired credentials
+// and suspended accounts. Access tokens are also rejected once their session
+// has been revoked.
+func AuthMiddleware(manager *tokens.Manager, apiKeys APIKeyStore, accounts AccountStatus) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// API keys come in their own header or as an ApiKey authorization
+		if key := apiKeyFromRequest(c); key != "" {
+			if err := authenticateAPIKey(c, apiKeys, key); err != nil {
+				return err
+			}
+			return checkSuspended(c, accounts)
+		}
+
+		// Extract token from the Authorization header
+		tokenHeader := c.Get("Authorization")
+		if tokenHeader == "" {
+			return apperr.Unauthorized("No token provided")
+		}
+
+		// Split the token header to get the token part
+		tokenParts := strings.Split(tokenHeader, "Bearer ")
+		if len(tokenParts) != 2 {
+			return apperr.Unauthorized("Invalid token format")
+		}
+		tokenString := tokenParts[1]
 
-	// Parse the JWT token
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Validate the token signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method")
+		// Parse the JWT token and check the denylist
+		claims, err := manager.ParseAccessToken(context.Background(), tokenString)
+		if err == tokens.ErrInvalidToken {
+			return apperr.Unauthorized("Invalid token")
 		}
+		if err == tokens.ErrRevoked {
+			return apperr.Unauthorized("Token has been revoked")
+		}
+		if err != nil {
+			return apperr.Internal("Failed to verify token", err)
+		}
+		active, err := accounts.SessionActive(context.Background(), claims.FamilyID, c.IP())
+		if err != nil {
+			return apperr.Internal("Failed to check session", err)
+		}
+		if !active {
+			return apperr.Unauthorized("Session has been revoked")
+		}
+
+		// Store user ID and claims in context
+		c.Locals("userID", claims.UserID)
+		c.Locals("claims", claims)
 
-		return []byte(config.SECRET_KEY), nil
-	})
+		return checkSuspended(c, accounts)
+	}
+}
+
+func checkSuspended(c *fiber.Ctx, accounts AccountStatus) error {
+	suspended, err := accounts.Suspended(context.Background(), c.Locals("userID").(string))
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Invalid token",
-		})
+		return apperr.Internal("Failed to check account", err)
+	}
+	if suspended {
+		return apperr.Forbidden("Account has been suspended")
+	}
+	return c.Next()
+}
+
+func apiKeyFromRequest(c *fiber.Ctx) string {
+	if key := c.Get("X-API-Key"); key != "" {
+		return key
 	}
+	if key, ok := strings.CutPrefix(c.Get("Authorization"), "ApiKey "); ok {
+		return key
+	}
+	return ""
+}
 
-	// Extract claims
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Invalid token claims",
-		})
+func authenticateAPIKey(c *fiber.Ctx, apiKeys APIKeyStore, key string) error {
+	apiKey, err := apiKeys.GetByHash(context.Background(), tokens.HashOpaqueToken(key))
+	if err == repository.ErrNotFound {
+		return apperr.Unauthorized("Invalid API key")
+	}
+	if err != nil {
+		return apperr.Internal("Failed to verify API key", err)
+	}
+	if apiKey.Expired() {
+		return apperr.Unauthorized("API key has expired")
 	}
 
-	// Extract user ID from claims
-	userID, ok := claims["_id"].(string)
-	if !ok || userID == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "User ID not found in token",
-		})
+	c.Locals("userID", apiKey.UserID)
+	c.Locals("apiKey", apiKey)
+
+	return nil
+}
+
+// RequireScope returns a middleware that lets API keys through only if they
+// have scope. Requests made with an access token are not limited.
+func RequireScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if apiKey, ok := c.Locals("apiKey").(*models.APIKey); ok && !apiKey.HasScope(scope) {
+			return apperr.Forbidden("API key does not have the " + scope + " scope")
+		}
+		return c.Next()
 	}
+}
 
-	// Store user ID in context
-	c.Locals("userID", userID)
+// RequireSession returns a middleware that refuses API keys, for routes that
+// manage the account itself
+func RequireSession() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, ok := c.Locals("claims").(*tokens.Claims); !ok {
+			return apperr.Forbidden("This endpoint requires logging in")
+		}
+		return c.Next()
+	}
+}
 
-	return c.Next()
+// RequireRole returns a middleware that only lets users with role through.
+// The role comes from the access token, so API keys never pass.
+func RequireRole(role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, ok := c.Locals("claims").(*tokens.Claims)
+		if !ok || claims.Role != role {
+			return apperr.Forbidden("This endpoint requires the " + role + " role")
+		}
+		return c.Next()
+	}
 }
 
 // ExtractUserID extracts the user ID from the context
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS files (
+    file_id     TEXT PRIMARY KEY,
+    filename    TEXT NOT NULL,
+    upload_date TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    s3_url      TEXT NOT NULL,
+    user_id     TEXT NOT NULL
+);
+
+-- Listing and search filter on the owner, the deletion job on the upload date
+CREATE INDEX IF NOT EXISTS files_user_id_idx ON files (user_id, upload_date);
+CREATE INDEX IF NOT EXISTS files_upload_date_idx ON files (upload_date);
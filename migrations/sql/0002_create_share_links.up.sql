@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS share_links (
+    link_id    TEXT PRIMARY KEY,
+    file_id    TEXT NOT NULL,
+    user_id    TEXT NOT NULL,
+    url        TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS share_links_file_id_idx ON share_links (file_id, expires_at);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS shares (
+    share_id         TEXT PRIMARY KEY,
+    token_hash       TEXT NOT NULL UNIQUE,
+    file_id          TEXT NOT NULL,
+    user_id          TEXT NOT NULL,
+    password_hash    TEXT,
+    expires_at       TIMESTAMPTZ,
+    max_downloads    INTEGER,
+    download_count   INTEGER NOT NULL DEFAULT 0,
+    last_accessed_at TIMESTAMPTZ,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    revoked_at       TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS shares_file_id_idx ON shares (file_id);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS file_permissions (
+    file_id    TEXT NOT NULL,
+    user_id    TEXT NOT NULL,
+    permission TEXT NOT NULL CHECK (permission IN ('read', 'edit')),
+    granted_by TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (file_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS file_permissions_user_id_idx ON file_permissions (user_id);
@@ -0,0 +1,3 @@
+ALTER TABLE files DROP COLUMN IF EXISTS version;
+ALTER TABLE files DROP COLUMN IF EXISTS tags;
+ALTER TABLE files DROP COLUMN IF EXISTS description;
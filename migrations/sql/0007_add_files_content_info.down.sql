@@ -0,0 +1,3 @@
+ALTER TABLE files DROP COLUMN IF EXISTS sha256;
+ALTER TABLE files DROP COLUMN IF EXISTS content_type;
+ALTER TABLE files DROP COLUMN IF EXISTS file_size;
@@ -0,0 +1,4 @@
+-- Files uploaded before these were recorded keep the defaults
+ALTER TABLE files ADD COLUMN IF NOT EXISTS file_size BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE files ADD COLUMN IF NOT EXISTS content_type TEXT NOT NULL DEFAULT '';
+ALTER TABLE files ADD COLUMN IF NOT EXISTS sha256 TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// Scopes an API key can be limited to
+const (
+	ScopeRead   = "read"
+	ScopeUpload = "upload"
+	ScopeShare  = "share"
+	ScopeDelete = "delete"
+)
+
+// Scopes lists every scope, in the order they are shown.
+var Scopes = []string{ScopeRead, ScopeUpload, ScopeShare, ScopeDelete}
+
+// APIKeyRequest is the request body for creating an API key
+type APIKeyRequest struct {
+	Name      string     `json:"name" validate:"required,max=100"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at" validate:"omitempty,gt"`
+}
+
+// APIKey is a long-lived credential for scripts. Only a hash of the key is
+// kept; Prefix is its first characters so users can tell their keys apart.
+type APIKey struct {
+	ID        string     `bson:"_id" json:"id"`
+	UserID    string     `bson:"user_id" json:"-"`
+	Name      string     `bson:"name" json:"name"`
+	Prefix    string     `bson:"prefix" json:"prefix"`
+	KeyHash   string     `bson:"key_hash" json:"-"`
+	Scopes    []string   `bson:"scopes" json:"scopes"`
+	CreatedAt time.Time  `bson:"created_at" json:"created_at"`
+	ExpiresAt *time.Time `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+}
+
+// HasScope reports whether the key grants scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired reports whether the key has passed its expiry.
+func (k *APIKey) Expired() bool {
+	return k.ExpiresAt != nil && !time.Now().Before(*k.ExpiresAt)
+}
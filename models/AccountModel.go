@@ -0,0 +1,74 @@
+package models
+
+import "trademarkia/validation"
+
+// TokenRequest is the request body for verifying an email address
+type TokenRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// EmailRequest is the request body for asking for a verification or password
+// reset email
+type EmailRequest struct {
+	Email string `json:"email" validate:"required,email,max=254"`
+}
+
+func (r *EmailRequest) Normalize() {
+	r.Email = validation.NormalizeEmail(r.Email)
+}
+
+// ResetPassword is the request body for setting a new password with a reset
+// token
+type ResetPassword struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=8,password"`
+}
+
+// MFACode is the request body for confirming or disabling two-factor
+// authentication. Code is a TOTP code or, where accepted, a recovery code.
+type MFACode struct {
+	Code string `json:"code" validate:"required,max=64"`
+}
+
+// MFALogin is the request body for completing a login that requires a second
+// factor
+type MFALogin struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required,max=64"`
+}
+
+// RoleRequest is the request body for changing a user's role
+type RoleRequest struct {
+	Role string `json:"role" validate:"required,oneof=user admin"`
+}
+
+// ProfileRequest is the request body for updating the current user. Fields
+// left out are not changed.
+type ProfileRequest struct {
+	Username    *string `json:"username" validate:"omitempty,min=3,max=32,username"`
+	DisplayName *string `json:"display_name" validate:"omitempty,max=100"`
+	Timezone    *string `json:"timezone" validate:"omitempty,timezone"`
+}
+
+// ChangePassword is the request body for changing the current user's password
+type ChangePassword struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password" validate:"required,min=8,password"`
+}
+
+// ChangeEmail is the request body for moving the account to a new email
+// address
+type ChangeEmail struct {
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Password string `json:"password"`
+}
+
+func (r *ChangeEmail) Normalize() {
+	r.Email = validation.NormalizeEmail(r.Email)
+}
+
+// PasswordConfirmation is the request body for actions that need the user's
+// password again, like deleting the account
+type PasswordConfirmation struct {
+	Password string `json:"password" validate:"bcrypt"`
+}
@@ -3,10 +3,36 @@ package models
 
 import "time"
 
-type FileMetadata struct {
-	UserID     string    `json:"user_id" bson:"user_id"`
-	FileName   string    `json:"file_name" bson:"file_name"`
-	UploadDate time.Time `json:"upload_date" bson:"upload_date"`
-	FileSize   int64     `json:"file_size" bson:"file_size"`
-	URL        string    `json:"url" bson:"url"`
+// UpdateFileMetadata is the request body for PATCH /files/:file_id. Nil fields
+// are left unchanged.
+type UpdateFileMetadata struct {
+	Filename    *string   `json:"filename" validate:"omitempty,notblank,max=255,filename"`
+	Description *string   `json:"description" validate:"omitempty,max=2000"`
+	Tags        *[]string `json:"tags"`
+	Version     *int      `json:"version" validate:"omitempty,min=1"`
+}
+
+// UploadedFile is the part of an upload that is validated, once the filename
+// has been sanitised.
+type UploadedFile struct {
+	Filename string `json:"filename" validate:"required,max=255,filename"`
+}
+
+// File is a row of the files table as returned by the API, including the
+// response to POST /upload. Permission is the
+// requesting user's access to the file: "owner", "edit" or "read".
+type File struct {
+	FileID      string     `json:"file_id"`
+	Filename    string     `json:"filename"`
+	UploadDate  time.Time  `json:"upload_date"`
+	S3URL       string     `json:"s3_url"`
+	UserID      string     `json:"owner_id"`
+	Permission  string     `json:"permission,omitempty"`
+	FileSize    int64      `json:"file_size"`
+	ContentType string     `json:"content_type"`
+	SHA256      string     `json:"sha256"`
+	Description string     `json:"description"`
+	Tags        []string   `json:"tags"`
+	Version     int        `json:"version"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
 }
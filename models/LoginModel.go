@@ -1,7 +1,14 @@
 package models
 
+import "trademarkia/validation"
 
+// LoginUser only checks that the fields are there: passwords set before the
+// password policy existed must still work.
 type LoginUser struct {
-	Email string `json:"email"`
-	Password string `json:"password"`
-}
\ No newline at end of file
+	Email    string `json:"email" validate:"required,max=254"`
+	Password string `json:"password" validate:"required"`
+}
+
+func (u *LoginUser) Normalize() {
+	u.Email = validation.NormalizeEmail(u.Email)
+}
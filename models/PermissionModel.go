@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// GrantPermission is the request body for sharing a file with another user.
+// User may be either a username or an email address.
+type GrantPermission struct {
+	User       string `json:"user" validate:"required,max=254"`
+	Permission string `json:"permission" validate:"required,oneof=read edit"`
+}
+
+// FileGrant gives a user other than the owner access to a file
+type FileGrant struct {
+	FileID     string    `json:"-"`
+	UserID     string    `json:"user_id"`
+	Username   string    `json:"username"`
+	Permission string    `json:"permission"`
+	GrantedBy  string    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+}
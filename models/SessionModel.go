@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Session is a login: every access and refresh token issued from it shares
+// its ID as their family. It lasts until it is revoked or goes unrefreshed
+// for REFRESH_TOKEN_TTL.
+type Session struct {
+	ID         string     `bson:"_id" json:"id"`
+	UserID     string     `bson:"user_id" json:"-"`
+	UserAgent  string     `bson:"user_agent" json:"user_agent"`
+	IP         string     `bson:"ip" json:"ip"`
+	CreatedAt  time.Time  `bson:"created_at" json:"created_at"`
+	LastSeenAt time.Time  `bson:"last_seen_at" json:"last_seen_at"`
+	ExpiresAt  time.Time  `bson:"expires_at" json:"expires_at"`
+	RevokedAt  *time.Time `bson:"revoked_at,omitempty" json:"-"`
+
+	// Current marks the session the request listing sessions was made from
+	Current bool `bson:"-" json:"current"`
+}
+
+// Active reports whether the session can still be used.
+func (s *Session) Active() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
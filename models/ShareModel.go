@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// CreateShare is the request body for creating a public share token
+type CreateShare struct {
+	Password     string `json:"password" validate:"bcrypt"`
+	ExpiresIn    string `json:"expires_in"`
+	MaxDownloads *int   `json:"max_downloads" validate:"omitempty,gt=0"`
+}
+
+// ShareLinkRequest is the optional request body for POST /share/:file_id. TTL
+// is a Go duration or a number of seconds.
+type ShareLinkRequest struct {
+	TTL string `json:"ttl" validate:"max=32"`
+}
+
+// ShareLink is a presigned download link issued for a file
+type ShareLink struct {
+	LinkID    string    `json:"link_id"`
+	FileID    string    `json:"-"`
+	UserID    string    `json:"-"`
+	URL       string    `json:"share_url"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Share is a revocable public share token. Only a hash of the token is kept.
+type Share struct {
+	ShareID        string     `json:"share_id"`
+	TokenHash      string     `json:"-"`
+	FileID         string     `json:"-"`
+	Filename       string     `json:"-"`
+	UserID         string     `json:"-"`
+	PasswordHash   *string    `json:"-"`
+	Protected      bool       `json:"protected"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	MaxDownloads   *int       `json:"max_downloads"`
+	DownloadCount  int        `json:"download_count"`
+	LastAccessedAt *time.Time `json:"last_accessed_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	RevokedAt      *time.Time `json:"revoked_at"`
+}
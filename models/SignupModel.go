@@ -1,7 +1,13 @@
 package models
 
+import "trademarkia/validation"
+
 type SignupUser struct {
-	Email    string `json:"email"`
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Username string `json:"username" validate:"required,min=3,max=32,username"`
+	Password string `json:"password" validate:"required,min=8,password"`
+}
+
+func (u *SignupUser) Normalize() {
+	u.Email = validation.NormalizeEmail(u.Email)
 }
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// RefreshRequest is the request body for exchanging a refresh token
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// RefreshToken is a single-use refresh token. Only a hash of the token is
+// kept. Every token refreshed from the same login shares a FamilyID.
+type RefreshToken struct {
+	ID        string     `bson:"_id"`
+	TokenHash string     `bson:"token_hash"`
+	UserID    string     `bson:"user_id"`
+	FamilyID  string     `bson:"family_id"`
+	CreatedAt time.Time  `bson:"created_at"`
+	ExpiresAt time.Time  `bson:"expires_at"`
+	UsedAt    *time.Time `bson:"used_at,omitempty"`
+	RevokedAt *time.Time `bson:"revoked_at,omitempty"`
+}
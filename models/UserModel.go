@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Roles a user can have
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// User is an account as stored in the MongoDB users collection.
+type User struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Email    string             `bson:"email" json:"email"`
+	Username string             `bson:"username" json:"username"`
+	Password string             `bson:"password" json:"-"`
+
+	EmailVerified bool `bson:"email_verified" json:"email_verified"`
+	// PendingEmail is the address the user asked to change to, until they
+	// confirm it
+	PendingEmail string `bson:"pending_email,omitempty" json:"pending_email,omitempty"`
+
+	// Display settings
+	DisplayName string `bson:"display_name,omitempty" json:"display_name"`
+	Timezone    string `bson:"timezone,omitempty" json:"timezone"`
+
+	// Role is empty for accounts created before roles existed, which are
+	// plain users
+	Role      string `bson:"role,omitempty" json:"role"`
+	Suspended bool   `bson:"suspended" json:"suspended"`
+
+	// TOTPSecret is set on enrollment; MFAEnabled once the user has proved
+	// they can generate codes with it
+	MFAEnabled    bool     `bson:"mfa_enabled" json:"mfa_enabled"`
+	TOTPSecret    string   `bson:"totp_secret,omitempty" json:"-"`
+	RecoveryCodes []string `bson:"recovery_codes,omitempty" json:"-"`
+
+	// Identities are the OpenID Connect accounts the user can sign in with
+	Identities []Identity `bson:"identities,omitempty" json:"-"`
+
+	// DeletedAt is set when the user deletes their account. The account is
+	// purged in the background from then on.
+	DeletedAt *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+}
+
+// Identity is an account at an OpenID Connect provider, identified by the
+// provider's issuer URL and its subject.
+type Identity struct {
+	Issuer  string `bson:"issuer"`
+	Subject string `bson:"subject"`
+}
+
+// RoleName returns the user's role, defaulting to RoleUser.
+func (u *User) RoleName() string {
+	if u.Role == "" {
+		return RoleUser
+	}
+	return u.Role
+}
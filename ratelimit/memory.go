@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryLimiter counts requests in process memory.
+type MemoryLimiter struct {
+	mu       sync.Mutex
+	windows  map[string][]time.Time
+	failures map[string]*failures
+	swept    time.Time
+}
+
+// failures is the lockout state of a key.
+type failures struct {
+	count       int
+	forgetAt    time.Time
+	lockedUntil time.Time
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{windows: make(map[string][]time.Time), failures: make(map[string]*failures)}
+}
+
+// sweepInterval is how often keys nobody has used for a while are dropped
+const sweepInterval = time.Minute
+
+func (l *MemoryLimiter) Allow(ctx context.Context, key string, rate Rate) (Result, error) {
+	if rate.Disabled() {
+		return Result{Allowed: true}, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.swept) > sweepInterval {
+		l.sweep(now)
+	}
+
+	hits := l.windows[key]
+	start := 0
+	for start < len(hits) && !hits[start].After(now.Add(-rate.Window)) {
+		start++
+	}
+	hits = hits[start:]
+
+	if len(hits) >= rate.Limit {
+		l.windows[key] = hits
+		return Result{RetryAfter: hits[0].Add(rate.Window).Sub(now)}, nil
+	}
+	l.windows[key] = append(hits, now)
+	return Result{Allowed: true, Remaining: rate.Limit - len(hits) - 1}, nil
+}
+
+func (l *MemoryLimiter) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if f, ok := l.failures[key]; ok && f.lockedUntil.After(time.Now()) {
+		return f.lockedUntil, nil
+	}
+	return time.Time{}, nil
+}
+
+func (l *MemoryLimiter) Fail(ctx context.Context, key string, lockout Lockout) (time.Time, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	f, ok := l.failures[key]
+	if !ok || now.After(f.forgetAt) {
+		f = &failures{}
+		l.failures[key] = f
+	}
+	f.count++
+	f.forgetAt = now.Add(lockout.Memory)
+	if d := lockout.duration(f.count); d > 0 {
+		f.lockedUntil = lockedUntil(d)
+		return f.lockedUntil, nil
+	}
+	return time.Time{}, nil
+}
+
+func (l *MemoryLimiter) Reset(ctx context.Context, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, key)
+	return nil
+}
+
+// sweep drops keys with no hits in the last hour and failures that have been
+// forgotten. The caller must hold l.mu.
+func (l *MemoryLimiter) sweep(now time.Time) {
+	for key, hits := range l.windows {
+		if len(hits) == 0 || now.Sub(hits[len(hits)-1]) > time.Hour {
+			delete(l.windows, key)
+		}
+	}
+	for key, f := range l.failures {
+		if now.After(f.forgetAt) && now.After(f.lockedUntil) {
+			delete(l.failures, key)
+		}
+	}
+	l.swept = now
+}
@@ -0,0 +1,174 @@
+// Package ratelimit counts requests in sliding windows and locks keys out
+// after repeated failures: in Redis, so limits hold across every server, or in
+// memory.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate is a number of requests allowed per window. A zero Rate allows
+// everything.
+type Rate struct {
+	Limit  int
+	Window time.Duration
+}
+
+// ParseRate parses a rate written as "10/1m", ten requests a minute. An empty
+// string or "0" disables the limit.
+func ParseRate(s string) (Rate, error) {
+	if s == "" || s == "0" {
+		return Rate{}, nil
+	}
+	count, window, ok := strings.Cut(s, "/")
+	if !ok {
+		return Rate{}, fmt.Errorf("ratelimit: rate %q is not of the form count/window", s)
+	}
+	limit, err := strconv.Atoi(count)
+	if err != nil || limit < 0 {
+		return Rate{}, fmt.Errorf("ratelimit: invalid count in rate %q", s)
+	}
+	duration, err := time.ParseDuration(window)
+	if err != nil || duration <= 0 {
+		return Rate{}, fmt.Errorf("ratelimit: invalid window in rate %q", s)
+	}
+	return Rate{Limit: limit, Window: duration}, nil
+}
+
+// Disabled reports whether the rate allows everything.
+func (r Rate) Disabled() bool {
+	return r.Limit <= 0 || r.Window <= 0
+}
+
+func (r Rate) String() string {
+	if r.Disabled() {
+		return "0"
+	}
+	return fmt.Sprintf("%d/%s", r.Limit, r.Window)
+}
+
+// Result is the outcome of counting a request.
+type Result struct {
+	Allowed bool
+	// Remaining is how many more requests the window allows
+	Remaining int
+	// RetryAfter is how long until a request is allowed again, when it is not
+	RetryAfter time.Duration
+}
+
+// Lockout locks a key out once it has failed Threshold times in a row: for
+// Base at first, doubling with every further failure up to Max. Failures are
+// forgotten Memory after the last one. A zero Threshold never locks.
+type Lockout struct {
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+	Memory    time.Duration
+}
+
+// duration returns how long the failures-th failure in a row locks the key
+// out for, or zero if it does not.
+func (l Lockout) duration(failures int) time.Duration {
+	excess := failures - l.Threshold
+	if l.Threshold <= 0 || excess < 0 {
+		return 0
+	}
+	if excess < 30 && l.Base<<excess < l.Max {
+		return l.Base << excess
+	}
+	return l.Max
+}
+
+// lockedUntil returns when a lockout of d starting now ends, rounded up to
+// the second so it can be reported exactly.
+func lockedUntil(d time.Duration) time.Time {
+	return time.Now().Add(d).Truncate(time.Second).Add(time.Second)
+}
+
+// Limiter counts requests per key in a sliding window of rate.Window. Only
+// allowed requests are counted, so clients that keep retrying are let
+// through again as soon as their oldest request leaves the window.
+//
+// It also counts failures per key for lockouts. Keys are shared with Allow,
+// so callers should keep the two apart.
+type Limiter interface {
+	Allow(ctx context.Context, key string, rate Rate) (Result, error)
+	// LockedUntil returns when the key's lockout ends, or the zero time if it
+	// is not locked out.
+	LockedUntil(ctx context.Context, key string) (time.Time, error)
+	// Fail counts a failure and returns when the lockout it triggers ends, or
+	// the zero time if it does not trigger one.
+	Fail(ctx context.Context, key string, lockout Lockout) (time.Time, error)
+	// Reset forgets the key's failures.
+	Reset(ctx context.Context, key string) error
+}
+
+// Fallback uses primary, and fallback while primary is failing. Limits are
+// then per server instead of shared, which is better than none.
+type Fallback struct {
+	primary  Limiter
+	fallback Limiter
+
+	mu      sync.Mutex
+	failing bool
+}
+
+func NewFallback(primary, fallback Limiter) *Fallback {
+	return &Fallback{primary: primary, fallback: fallback}
+}
+
+func (f *Fallback) Allow(ctx context.Context, key string, rate Rate) (Result, error) {
+	result, err := f.primary.Allow(ctx, key, rate)
+	f.setFailing(err)
+	if err != nil {
+		return f.fallback.Allow(ctx, key, rate)
+	}
+	return result, nil
+}
+
+func (f *Fallback) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	until, err := f.primary.LockedUntil(ctx, key)
+	f.setFailing(err)
+	if err != nil {
+		return f.fallback.LockedUntil(ctx, key)
+	}
+	return until, nil
+}
+
+func (f *Fallback) Fail(ctx context.Context, key string, lockout Lockout) (time.Time, error) {
+	until, err := f.primary.Fail(ctx, key, lockout)
+	f.setFailing(err)
+	if err != nil {
+		return f.fallback.Fail(ctx, key, lockout)
+	}
+	return until, nil
+}
+
+func (f *Fallback) Reset(ctx context.Context, key string) error {
+	err := f.primary.Reset(ctx, key)
+	f.setFailing(err)
+	if err != nil {
+		return f.fallback.Reset(ctx, key)
+	}
+	return nil
+}
+
+// setFailing logs when the primary limiter starts and stops failing.
+func (f *Fallback) setFailing(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if failing := err != nil; failing != f.failing {
+		f.failing = failing
+		if failing {
+			log.Println("Rate limiter unavailable, counting in memory:", err)
+		} else {
+			log.Println("Rate limiter available again")
+		}
+	}
+}
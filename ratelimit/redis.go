@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// slidingWindow keeps the times of the allowed requests in a sorted set and
+// returns {allowed, remaining, retry after in milliseconds}.
+var slidingWindow = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+if count < limit then
+	redis.call('ZADD', key, now, ARGV[4])
+	redis.call('PEXPIRE', key, window)
+	return {1, limit - count - 1, 0}
+end
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+return {0, 0, tonumber(oldest[2]) + window - now}
+`)
+
+// recordFailure counts a failure in KEYS[1], forgetting it after ARGV[1]
+// milliseconds, and returns the number of failures in a row.
+var recordFailure = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[1])
+return count
+`)
+
+// RedisLimiter counts requests in Redis, so the limits are shared by every
+// server.
+type RedisLimiter struct {
+	client *redis.Client
+}
+
+func NewRedisLimiter(client *redis.Client) *RedisLimiter {
+	return &RedisLimiter{client: client}
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string, rate Rate) (Result, error) {
+	if rate.Disabled() {
+		return Result{Allowed: true}, nil
+	}
+
+	values, err := slidingWindow.Run(ctx, l.client, []string{"ratelimit:" + key},
+		time.Now().UnixMilli(), rate.Window.Milliseconds(), rate.Limit, uuid.New().String()).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Allowed:    values[0] == 1,
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+	}, nil
+}
+
+func failuresKey(key string) string {
+	return "lockout:failures:" + key
+}
+
+func lockedKey(key string) string {
+	return "lockout:locked:" + key
+}
+
+func (l *RedisLimiter) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	unix, err := l.client.Get(ctx, lockedKey(key)).Int64()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if until := time.Unix(unix, 0); until.After(time.Now()) {
+		return until, nil
+	}
+	return time.Time{}, nil
+}
+
+func (l *RedisLimiter) Fail(ctx context.Context, key string, lockout Lockout) (time.Time, error) {
+	count, err := recordFailure.Run(ctx, l.client, []string{failuresKey(key)}, lockout.Memory.Milliseconds()).Int()
+	if err != nil {
+		return time.Time{}, err
+	}
+	d := lockout.duration(count)
+	if d <= 0 {
+		return time.Time{}, nil
+	}
+	until := lockedUntil(d)
+	if err := l.client.Set(ctx, lockedKey(key), until.Unix(), time.Until(until)).Err(); err != nil {
+		return time.Time{}, err
+	}
+	return until, nil
+}
+
+func (l *RedisLimiter) Reset(ctx context.Context, key string) error {
+	return l.client.Del(ctx, failuresKey(key)).Err()
+}
@@ -0,0 +1,248 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"trademarkia/models"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// FileRepository stores file metadata in the files table.
+type FileRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewFileRepository(pool *pgxpool.Pool) *FileRepository {
+	return &FileRepository{pool: pool}
+}
+
+// SearchParams filters the files visible to UserID. Shared selects the files
+// other users have shared with them instead of their own uploads. Name
+// matches part of the filename; a zero Date matches any upload day.
+type SearchParams struct {
+	UserID string
+	Shared bool
+	Name   string
+	Date   time.Time
+	Limit  int
+	Offset int
+}
+
+// likeEscaper escapes the LIKE wildcards so a name is matched literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// FileUpdate lists the fields to change; nil fields are left as they are.
+type FileUpdate struct {
+	Filename    *string
+	Description *string
+	Tags        *[]string
+}
+
+const fileColumns = `f.file_id, f.filename, f.upload_date, f.s3_url, f.user_id, f.file_size, f.content_type, f.sha256,
+	f.description, f.tags, f.version, f.deleted_at`
+
+func scanFile(row pgx.Row, extra ...interface{}) (*models.File, error) {
+	var file models.File
+	dest := append([]interface{}{&file.FileID, &file.Filename, &file.UploadDate, &file.S3URL, &file.UserID,
+		&file.FileSize, &file.ContentType, &file.SHA256, &file.Description, &file.Tags, &file.Version, &file.DeletedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &file, nil
+}
+
+func (r *FileRepository) Create(ctx context.Context, file *models.File) error {
+	_, err := r.pool.Exec(ctx, `INSERT INTO files (file_id, filename, upload_date, s3_url, user_id, file_size, content_type, sha256)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		file.FileID, file.Filename, file.UploadDate, file.S3URL, file.UserID, file.FileSize, file.ContentType, file.SHA256)
+	return err
+}
+
+// Get returns a file, including one in the trash, along with userID's
+// permission on it. Permission is empty when the user has no access.
+func (r *FileRepository) Get(ctx context.Context, fileID, userID string) (*models.File, error) {
+	var granted *string
+	file, err := scanFile(r.pool.QueryRow(ctx, `SELECT `+fileColumns+`, p.permission
+		FROM files f LEFT JOIN file_permissions p ON p.file_id = f.file_id AND p.user_id = $2
+		WHERE f.file_id = $1`, fileID, userID), &granted)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case file.UserID == userID:
+		file.Permission = "owner"
+	case granted != nil:
+		file.Permission = *granted
+	}
+	return file, nil
+}
+
+// ListByUser returns the files visible to userID that are not in the trash.
+func (r *FileRepository) ListByUser(ctx context.Context, userID string, shared bool) ([]models.File, error) {
+	return r.Search(ctx, SearchParams{UserID: userID, Shared: shared})
+}
+
+// Search is ListByUser with optional name and upload date filters and
+// pagination. A Limit of zero returns every match.
+func (r *FileRepository) Search(ctx context.Context, params SearchParams) ([]models.File, error) {
+	var query string
+	if params.Shared {
+		query = `SELECT ` + fileColumns + `, p.permission FROM files f
+			JOIN file_permissions p ON p.file_id = f.file_id WHERE p.user_id = $1 AND f.deleted_at IS NULL`
+	} else {
+		query = `SELECT ` + fileColumns + `, 'owner' FROM files f WHERE f.user_id = $1 AND f.deleted_at IS NULL`
+	}
+	args := []interface{}{params.UserID}
+
+	if params.Name != "" {
+		args = append(args, "%"+likeEscaper.Replace(params.Name)+"%")
+		query += fmt.Sprintf(` AND f.filename ILIKE $%d`, len(args))
+	}
+	if !params.Date.IsZero() {
+		args = append(args, params.Date.Format(time.DateOnly))
+		query += fmt.Sprintf(` AND f.upload_date::date = $%d::date`, len(args))
+	}
+	query += ` ORDER BY f.upload_date DESC`
+	if params.Limit > 0 {
+		args = append(args, params.Limit, params.Offset)
+		query += fmt.Sprintf(` LIMIT $%d OFFSET $%d`, len(args)-1, len(args))
+	}
+
+	return r.queryFiles(ctx, query, args...)
+}
+
+// ListTrashed returns userID's files that are in the trash, most recently
+// deleted first.
+func (r *FileRepository) ListTrashed(ctx context.Context, userID string) ([]models.File, error) {
+	return r.queryFiles(ctx, `SELECT `+fileColumns+`, 'owner' FROM files f
+		WHERE f.user_id = $1 AND f.deleted_at IS NOT NULL ORDER BY f.deleted_at DESC`, userID)
+}
+
+// ListExpired returns the files due for permanent deletion: live files
+// uploaded before uploadedBefore and files trashed before trashedBefore. A
+// trashed file is kept for the whole retention period, however old it is.
+func (r *FileRepository) ListExpired(ctx context.Context, uploadedBefore, trashedBefore time.Time) ([]models.File, error) {
+	return r.queryFiles(ctx, `SELECT `+fileColumns+`, 'owner' FROM files f
+		WHERE (f.deleted_at IS NULL AND f.upload_date < $1) OR f.deleted_at < $2`, uploadedBefore, trashedBefore)
+}
+
+// Usage sums up a user's files.
+type Usage struct {
+	Files        int
+	TrashedFiles int
+	Bytes        int64
+}
+
+// Usage counts userID's files, live and in the trash, and the bytes they
+// take up.
+func (r *FileRepository) Usage(ctx context.Context, userID string) (*Usage, error) {
+	var usage Usage
+	err := r.pool.QueryRow(ctx, `SELECT COUNT(*) FILTER (WHERE deleted_at IS NULL),
+		COUNT(*) FILTER (WHERE deleted_at IS NOT NULL), COALESCE(SUM(file_size), 0)
+		FROM files WHERE user_id = $1`, userID).Scan(&usage.Files, &usage.TrashedFiles, &usage.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// ListOwned returns up to limit of userID's files, including those in the
+// trash.
+func (r *FileRepository) ListOwned(ctx context.Context, userID string, limit int) ([]models.File, error) {
+	return r.queryFiles(ctx, `SELECT `+fileColumns+`, 'owner' FROM files f
+		WHERE f.user_id = $1 ORDER BY f.file_id LIMIT $2`, userID, limit)
+}
+
+func (r *FileRepository) queryFiles(ctx context.Context, query string, args ...interface{}) ([]models.File, error) {
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []models.File{}
+	for rows.Next() {
+		var permission string
+		file, err := scanFile(rows, &permission)
+		if err != nil {
+			return nil, err
+		}
+		file.Permission = permission
+		files = append(files, *file)
+	}
+	return files, rows.Err()
+}
+
+// Update applies update to a file that is not in the trash. When
+// expectedVersion is set the update only succeeds if the file is still at that
+// version, otherwise ErrVersionConflict is returned.
+func (r *FileRepository) Update(ctx context.Context, fileID string, update FileUpdate, expectedVersion *int) (*models.File, error) {
+	file, err := scanFile(r.pool.QueryRow(ctx, `UPDATE files f SET
+			filename = COALESCE($2, f.filename),
+			description = COALESCE($3, f.description),
+			tags = COALESCE($4, f.tags),
+			version = f.version + 1
+		WHERE f.file_id = $1 AND f.deleted_at IS NULL AND ($5::int IS NULL OR f.version = $5)
+		RETURNING `+fileColumns,
+		fileID, update.Filename, update.Description, update.Tags, expectedVersion))
+	// No row matched: the version is stale only if the file is still there
+	// and out of the trash
+	if err == ErrNotFound {
+		if current, getErr := r.Get(ctx, fileID, ""); getErr == nil && current.DeletedAt == nil {
+			return nil, ErrVersionConflict
+		}
+	}
+	return file, err
+}
+
+// Trash moves a file into the trash and returns when it was deleted.
+func (r *FileRepository) Trash(ctx context.Context, fileID string) (time.Time, error) {
+	var deletedAt time.Time
+	err := r.pool.QueryRow(ctx, `UPDATE files SET deleted_at = NOW() WHERE file_id = $1 AND deleted_at IS NULL RETURNING deleted_at`, fileID).Scan(&deletedAt)
+	if err == pgx.ErrNoRows {
+		return time.Time{}, ErrNotFound
+	}
+	return deletedAt, err
+}
+
+// Restore moves a file back out of the trash.
+func (r *FileRepository) Restore(ctx context.Context, fileID string) error {
+	tag, err := r.pool.Exec(ctx, `UPDATE files SET deleted_at = NULL WHERE file_id = $1 AND deleted_at IS NOT NULL`, fileID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Delete permanently removes a file and everything that refers to it. The
+// stored object must be deleted separately.
+func (r *FileRepository) Delete(ctx context.Context, fileID string) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, statement := range []string{
+		`DELETE FROM share_links WHERE file_id = $1`,
+		`DELETE FROM shares WHERE file_id = $1`,
+		`DELETE FROM file_permissions WHERE file_id = $1`,
+		`DELETE FROM files WHERE file_id = $1`,
+	} {
+		if _, err := tx.Exec(ctx, statement, fileID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
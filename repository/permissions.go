@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"trademarkia/models"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// PermissionRepository stores the access other users have been granted to
+// files.
+type PermissionRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewPermissionRepository(pool *pgxpool.Pool) *PermissionRepository {
+	return &PermissionRepository{pool: pool}
+}
+
+// Grant gives grant.UserID access to grant.FileID, replacing any permission
+// they already had.
+func (r *PermissionRepository) Grant(ctx context.Context, grant *models.FileGrant) error {
+	_, err := r.pool.Exec(ctx, `INSERT INTO file_permissions (file_id, user_id, permission, granted_by) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (file_id, user_id) DO UPDATE SET permission = EXCLUDED.permission, granted_by = EXCLUDED.granted_by`,
+		grant.FileID, grant.UserID, grant.Permission, grant.GrantedBy)
+	return err
+}
+
+func (r *PermissionRepository) List(ctx context.Context, fileID string) ([]models.FileGrant, error) {
+	rows, err := r.pool.Query(ctx, `SELECT file_id, user_id, permission, granted_by, created_at
+		FROM file_permissions WHERE file_id = $1 ORDER BY created_at`, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := []models.FileGrant{}
+	for rows.Next() {
+		var grant models.FileGrant
+		if err := rows.Scan(&grant.FileID, &grant.UserID, &grant.Permission, &grant.GrantedBy, &grant.CreatedAt); err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+	return grants, rows.Err()
+}
+
+func (r *PermissionRepository) Revoke(ctx context.Context, fileID, userID string) error {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM file_permissions WHERE file_id = $1 AND user_id = $2`, fileID, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteUser removes every permission granted to userID.
+func (r *PermissionRepository) DeleteUser(ctx context.Context, userID string) error {
+	_, err := r.pool.Exec(ctx, `DELETE FROM file_permissions WHERE user_id = $1`, userID)
+	return err
+}
@@ -0,0 +1,47 @@
+// Package repository holds the Postgres and MongoDB data access code shared
+// by the handlers and background jobs.
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"trademarkia/config"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var (
+	// ErrNotFound is returned when the requested row does not exist.
+	ErrNotFound = errors.New("repository: not found")
+	// ErrVersionConflict is returned when an update expected a version of the
+	// row that is no longer current.
+	ErrVersionConflict = errors.New("repository: version conflict")
+	// ErrDuplicate is returned when a write would break a unique index.
+	ErrDuplicate = errors.New("repository: duplicate")
+)
+
+// NewPool opens the connection pool used by every repository.
+func NewPool(ctx context.Context) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(postgresURL())
+	if err != nil {
+		return nil, err
+	}
+	poolConfig.MaxConns = int32(config.PG_MAX_CONNS)
+	poolConfig.MinConns = int32(config.PG_MIN_CONNS)
+	poolConfig.MaxConnLifetime = config.PG_MAX_CONN_LIFETIME
+	poolConfig.MaxConnIdleTime = config.PG_MAX_CONN_IDLE_TIME
+
+	return pgxpool.ConnectConfig(ctx, poolConfig)
+}
+
+func postgresURL() string {
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(config.PG_USER, config.PG_PASSWORD),
+		Host:   fmt.Sprintf("%s:%s", config.PG_HOST, config.PG_PORT),
+		Path:   config.PG_DBNAME,
+	}
+	return u.String()
+}
@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"context"
+	"time"
+	"trademarkia/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RefreshTokenRepository stores refresh tokens in MongoDB.
+type RefreshTokenRepository struct {
+	collection *mongo.Collection
+}
+
+func NewRefreshTokenRepository(db *mongo.Database) *RefreshTokenRepository {
+	return &RefreshTokenRepository{collection: db.Collection("refresh_tokens")}
+}
+
+// EnsureIndexes creates the lookup indexes and lets MongoDB drop tokens once
+// they have expired.
+func (r *RefreshTokenRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "family_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+func (r *RefreshTokenRepository) Create(ctx context.Context, token *models.RefreshToken) error {
+	_, err := r.collection.InsertOne(ctx, token)
+	return err
+}
+
+func (r *RefreshTokenRepository) GetByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := r.collection.FindOne(ctx, bson.M{"token_hash": tokenHash}).Decode(&token)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkUsed records that the token has been exchanged. It reports false if the
+// token had already been used or revoked, which can happen when the same
+// token is presented twice at once.
+func (r *RefreshTokenRepository) MarkUsed(ctx context.Context, tokenID string) (bool, error) {
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": tokenID, "used_at": nil, "revoked_at": nil},
+		bson.M{"$set": bson.M{"used_at": time.Now()}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// RevokeFamily revokes every refresh token of the family.
+func (r *RefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string) error {
+	_, err := r.collection.UpdateMany(ctx,
+		bson.M{"family_id": familyID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	return err
+}
+
+// RevokeUser revokes every refresh token of the user, except those of the
+// family keepFamilyID if it is set, and returns the families they belonged
+// to.
+func (r *RefreshTokenRepository) RevokeUser(ctx context.Context, userID, keepFamilyID string) ([]string, error) {
+	filter := bson.M{"user_id": userID, "revoked_at": nil}
+	if keepFamilyID != "" {
+		filter["family_id"] = bson.M{"$ne": keepFamilyID}
+	}
+	values, err := r.collection.Distinct(ctx, "family_id", filter)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked_at": time.Now()}}); err != nil {
+		return nil, err
+	}
+
+	families := make([]string, 0, len(values))
+	for _, value := range values {
+		if familyID, ok := value.(string); ok {
+			families = append(families, familyID)
+		}
+	}
+	return families, nil
+}
+
+// DeleteUser deletes every refresh token of the user.
+func (r *RefreshTokenRepository) DeleteUser(ctx context.Context, userID string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
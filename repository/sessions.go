@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"time"
+	"trademarkia/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SessionRepository stores login sessions in MongoDB.
+type SessionRepository struct {
+	collection *mongo.Collection
+}
+
+func NewSessionRepository(db *mongo.Database) *SessionRepository {
+	return &SessionRepository{collection: db.Collection("sessions")}
+}
+
+// EnsureIndexes creates the lookup index and lets MongoDB drop sessions once
+// they have expired.
+func (r *SessionRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+func (r *SessionRepository) Create(ctx context.Context, session *models.Session) error {
+	_, err := r.collection.InsertOne(ctx, session)
+	return err
+}
+
+func (r *SessionRepository) Get(ctx context.Context, sessionID string) (*models.Session, error) {
+	var session models.Session
+	err := r.collection.FindOne(ctx, bson.M{"_id": sessionID}).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ListActive returns the user's sessions that have not been revoked or
+// expired, most recently seen first.
+func (r *SessionRepository) ListActive(ctx context.Context, userID string) ([]models.Session, error) {
+	cursor, err := r.collection.Find(ctx,
+		bson.M{"user_id": userID, "revoked_at": nil, "expires_at": bson.M{"$gt": time.Now()}},
+		options.Find().SetSort(bson.D{{Key: "last_seen_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	sessions := []models.Session{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// Touch records that the session was used from ip at seenAt.
+func (r *SessionRepository) Touch(ctx context.Context, sessionID, ip string, seenAt time.Time) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": sessionID},
+		bson.M{"$set": bson.M{"ip": ip, "last_seen_at": seenAt}})
+	return err
+}
+
+// Extend keeps the session alive until expiresAt. It is called when the
+// session's tokens are refreshed.
+func (r *SessionRepository) Extend(ctx context.Context, sessionID string, expiresAt time.Time) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": sessionID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"expires_at": expiresAt}})
+	return err
+}
+
+func (r *SessionRepository) Revoke(ctx context.Context, sessionID string) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": sessionID, "revoked_at": nil},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	return err
+}
+
+// DeleteUser deletes every session of the user, revoked or not.
+func (r *SessionRepository) DeleteUser(ctx context.Context, userID string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"trademarkia/models"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// ShareRepository stores presigned share links and public share tokens.
+type ShareRepository struct {
+	pool *pgxpool.Pool
+}
+
+func NewShareRepository(pool *pgxpool.Pool) *ShareRepository {
+	return &ShareRepository{pool: pool}
+}
+
+func (r *ShareRepository) CreateLink(ctx context.Context, link *models.ShareLink) error {
+	_, err := r.pool.Exec(ctx, `INSERT INTO share_links (link_id, file_id, user_id, url, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		link.LinkID, link.FileID, link.UserID, link.URL, link.CreatedAt, link.ExpiresAt)
+	return err
+}
+
+// ListActiveLinks returns the links for a file that have not expired yet.
+func (r *ShareRepository) ListActiveLinks(ctx context.Context, fileID string) ([]models.ShareLink, error) {
+	rows, err := r.pool.Query(ctx, `SELECT link_id, file_id, user_id, url, created_at, expires_at
+		FROM share_links WHERE file_id = $1 AND expires_at > NOW() ORDER BY created_at DESC`, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []models.ShareLink{}
+	for rows.Next() {
+		var link models.ShareLink
+		if err := rows.Scan(&link.LinkID, &link.FileID, &link.UserID, &link.URL, &link.CreatedAt, &link.ExpiresAt); err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (r *ShareRepository) CreateShare(ctx context.Context, share *models.Share) error {
+	_, err := r.pool.Exec(ctx, `INSERT INTO shares (share_id, token_hash, file_id, user_id, password_hash, expires_at, max_downloads, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		share.ShareID, share.TokenHash, share.FileID, share.UserID, share.PasswordHash, share.ExpiresAt, share.MaxDownloads, share.CreatedAt)
+	return err
+}
+
+const shareColumns = `s.share_id, s.token_hash, s.file_id, f.filename, s.user_id, s.password_hash, s.expires_at,
+	s.max_downloads, s.download_count, s.last_accessed_at, s.created_at, s.revoked_at`
+
+func scanShare(row pgx.Row) (*models.Share, error) {
+	var share models.Share
+	err := row.Scan(&share.ShareID, &share.TokenHash, &share.FileID, &share.Filename, &share.UserID, &share.PasswordHash, &share.ExpiresAt,
+		&share.MaxDownloads, &share.DownloadCount, &share.LastAccessedAt, &share.CreatedAt, &share.RevokedAt)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	share.Protected = share.PasswordHash != nil
+	return &share, nil
+}
+
+// ListShares returns every share of a file, including revoked and expired
+// ones.
+func (r *ShareRepository) ListShares(ctx context.Context, fileID string) ([]models.Share, error) {
+	rows, err := r.pool.Query(ctx, `SELECT `+shareColumns+` FROM shares s JOIN files f ON f.file_id = s.file_id
+		WHERE s.file_id = $1 ORDER BY s.created_at DESC`, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []models.Share{}
+	for rows.Next() {
+		share, err := scanShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, *share)
+	}
+	return shares, rows.Err()
+}
+
+// GetShareByTokenHash resolves a share token. Shares of files in the trash are
+// not found.
+func (r *ShareRepository) GetShareByTokenHash(ctx context.Context, tokenHash string) (*models.Share, error) {
+	return scanShare(r.pool.QueryRow(ctx, `SELECT `+shareColumns+` FROM shares s JOIN files f ON f.file_id = s.file_id
+		WHERE s.token_hash = $1 AND f.deleted_at IS NULL`, tokenHash))
+}
+
+func (r *ShareRepository) RevokeShare(ctx context.Context, fileID, shareID string) error {
+	tag, err := r.pool.Exec(ctx, `UPDATE shares SET revoked_at = NOW() WHERE share_id = $1 AND file_id = $2 AND revoked_at IS NULL`, shareID, fileID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RecordShareAccess counts one download of a share. It returns false without
+// counting when the share has been revoked or its download limit is used up;
+// the limit is checked in the same statement so concurrent downloads cannot
+// exceed it.
+func (r *ShareRepository) RecordShareAccess(ctx context.Context, shareID string) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `UPDATE shares SET download_count = download_count + 1, last_accessed_at = NOW()
+		WHERE share_id = $1 AND revoked_at IS NULL AND (max_downloads IS NULL OR download_count < max_downloads)`, shareID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,391 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+	"trademarkia/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UserRepository stores accounts in MongoDB. Accounts waiting to be purged
+// after their owner deleted them are not found by lookups, but keep their
+// email address and username until they are purged.
+type UserRepository struct {
+	collection *mongo.Collection
+}
+
+func NewUserRepository(db *mongo.Database) *UserRepository {
+	return &UserRepository{collection: db.Collection("users")}
+}
+
+// emailCollation compares email addresses without regard to case. New
+// addresses are stored lowercased; the collation also covers those stored
+// as typed before that, so lookups by email use it too.
+var emailCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// EnsureIndexes makes email addresses, in any case, and usernames unique, so
+// two sign-ups racing for the same one cannot both succeed. It returns
+// ErrDuplicate if accounts already share one; FindDuplicates lists them.
+func (r *UserRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true).SetCollation(emailCollation)},
+		{Keys: bson.D{{Key: "username", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
+	return duplicate(err)
+}
+
+// Duplicate is an email address or username shared by several accounts,
+// which keeps EnsureIndexes from making it unique.
+type Duplicate struct {
+	Field   string
+	Value   string
+	UserIDs []string
+}
+
+// FindDuplicates lists the email addresses, in any case, and usernames held
+// by more than one account, deleted accounts included.
+func (r *UserRepository) FindDuplicates(ctx context.Context) ([]Duplicate, error) {
+	var duplicates []Duplicate
+	for field, key := range map[string]interface{}{
+		"email":    bson.M{"$toLower": "$email"},
+		"username": "$username",
+	} {
+		cursor, err := r.collection.Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$group", Value: bson.M{"_id": key, "ids": bson.M{"$push": "$_id"}, "count": bson.M{"$sum": 1}}}},
+			{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		var groups []struct {
+			Value string               `bson:"_id"`
+			IDs   []primitive.ObjectID `bson:"ids"`
+		}
+		if err := cursor.All(ctx, &groups); err != nil {
+			return nil, err
+		}
+		for _, group := range groups {
+			found := Duplicate{Field: field, Value: group.Value}
+			for _, id := range group.IDs {
+				found.UserIDs = append(found.UserIDs, id.Hex())
+			}
+			duplicates = append(duplicates, found)
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool {
+		if duplicates[i].Field != duplicates[j].Field {
+			return duplicates[i].Field < duplicates[j].Field
+		}
+		return duplicates[i].Value < duplicates[j].Value
+	})
+	return duplicates, nil
+}
+
+// duplicate reports writes rejected by a unique index as ErrDuplicate.
+func duplicate(err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+// Create inserts user, or returns ErrDuplicate if the email address or
+// username is taken.
+func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
+	result, err := r.collection.InsertOne(ctx, user)
+	if err != nil {
+		return duplicate(err)
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		user.ID = id
+	}
+	return nil
+}
+
+func (r *UserRepository) findOne(ctx context.Context, filter bson.M, opts ...*options.FindOneOptions) (*models.User, error) {
+	filter["deleted_at"] = nil
+	var user models.User
+	err := r.collection.FindOne(ctx, filter, opts...).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) GetByID(ctx context.Context, userID string) (*models.User, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return r.findOne(ctx, bson.M{"_id": id})
+}
+
+// GetByEmail finds a user by email address, in any case.
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.findOne(ctx, bson.M{"email": email}, options.FindOne().SetCollation(emailCollation))
+}
+
+// GetByLogin finds a user by either their email address or their username.
+// Usernames cannot contain "@", so that tells the two apart.
+func (r *UserRepository) GetByLogin(ctx context.Context, login string) (*models.User, error) {
+	if strings.Contains(login, "@") {
+		return r.GetByEmail(ctx, login)
+	}
+	return r.findOne(ctx, bson.M{"username": login})
+}
+
+// EmailExists reports whether an account uses email, in any case, including accounts
+// waiting to be purged.
+func (r *UserRepository) EmailExists(ctx context.Context, email string) (bool, error) {
+	count, err := r.collection.CountDocuments(ctx, bson.M{"email": email}, options.Count().SetCollation(emailCollation))
+	return count > 0, err
+}
+
+// UsernameExists reports whether an account uses username, including
+// accounts waiting to be purged.
+func (r *UserRepository) UsernameExists(ctx context.Context, username string) (bool, error) {
+	count, err := r.collection.CountDocuments(ctx, bson.M{"username": username})
+	return count > 0, err
+}
+
+// MarkEmailVerified marks the user's email address as verified, provided it
+// is still email.
+func (r *UserRepository) MarkEmailVerified(ctx context.Context, userID, email string) error {
+	return r.update(ctx, userID, bson.M{"email": email}, bson.M{"email_verified": true})
+}
+
+func (r *UserRepository) UpdatePassword(ctx context.Context, userID, passwordHash string) error {
+	return r.update(ctx, userID, nil, bson.M{"password": passwordHash})
+}
+
+// SetTOTPSecret stores a new TOTP secret for a user who has not enabled
+// two-factor authentication yet.
+func (r *UserRepository) SetTOTPSecret(ctx context.Context, userID, secret string) error {
+	return r.update(ctx, userID, bson.M{"mfa_enabled": bson.M{"$ne": true}}, bson.M{"totp_secret": secret})
+}
+
+// EnableMFA turns on two-factor authentication with the stored secret and
+// replaces the user's recovery codes.
+func (r *UserRepository) EnableMFA(ctx context.Context, userID string, recoveryCodeHashes []string) error {
+	return r.update(ctx, userID, nil, bson.M{"mfa_enabled": true, "recovery_codes": recoveryCodeHashes})
+}
+
+func (r *UserRepository) DisableMFA(ctx context.Context, userID string) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrNotFound
+	}
+	result, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set":   bson.M{"mfa_enabled": false},
+		"$unset": bson.M{"totp_secret": "", "recovery_codes": ""},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UseRecoveryCode removes a recovery code from the user, reporting false if
+// they did not have it. Each code can be used once.
+func (r *UserRepository) UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return false, nil
+	}
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "recovery_codes": codeHash},
+		bson.M{"$pull": bson.M{"recovery_codes": codeHash}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+func (r *UserRepository) GetByIdentity(ctx context.Context, identity models.Identity) (*models.User, error) {
+	return r.findOne(ctx, bson.M{"identities": bson.M{"$elemMatch": bson.M{
+		"issuer":  identity.Issuer,
+		"subject": identity.Subject,
+	}}})
+}
+
+// LinkIdentity lets the user sign in with identity.
+func (r *UserRepository) LinkIdentity(ctx context.Context, userID string, identity models.Identity) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrNotFound
+	}
+	result, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$addToSet": bson.M{"identities": identity}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// List returns users in the order they signed up, leaving out deleted
+// accounts. A limit of zero returns every user.
+func (r *UserRepository) List(ctx context.Context, limit, offset int) ([]models.User, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetSkip(int64(offset))
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := r.collection.Find(ctx, bson.M{"deleted_at": nil}, opts)
+	if err != nil {
+		return nil, err
+	}
+	users := []models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (r *UserRepository) SetRole(ctx context.Context, userID, role string) error {
+	return r.update(ctx, userID, nil, bson.M{"role": role})
+}
+
+func (r *UserRepository) SetSuspended(ctx context.Context, userID string, suspended bool) error {
+	return r.update(ctx, userID, nil, bson.M{"suspended": suspended})
+}
+
+// ProfileUpdate lists the profile fields to change; nil fields are left as
+// they are.
+type ProfileUpdate struct {
+	Username    *string
+	DisplayName *string
+	Timezone    *string
+}
+
+// UpdateProfile returns ErrDuplicate if the new username is taken.
+func (r *UserRepository) UpdateProfile(ctx context.Context, userID string, update ProfileUpdate) error {
+	fields := bson.M{}
+	if update.Username != nil {
+		fields["username"] = *update.Username
+	}
+	if update.DisplayName != nil {
+		fields["display_name"] = *update.DisplayName
+	}
+	if update.Timezone != nil {
+		fields["timezone"] = *update.Timezone
+	}
+	return r.update(ctx, userID, nil, fields)
+}
+
+// SetPendingEmail records the address the user wants to change to.
+func (r *UserRepository) SetPendingEmail(ctx context.Context, userID, email string) error {
+	return r.update(ctx, userID, nil, bson.M{"pending_email": email})
+}
+
+// ChangeEmail moves the user to email, which has been verified, provided it
+// is still the address they asked to change to. It returns ErrDuplicate if
+// another account has taken the address since.
+func (r *UserRepository) ChangeEmail(ctx context.Context, userID, email string) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrNotFound
+	}
+	result, err := r.collection.UpdateOne(ctx, bson.M{"_id": id, "pending_email": email}, bson.M{
+		"$set":   bson.M{"email": email, "email_verified": true},
+		"$unset": bson.M{"pending_email": ""},
+	})
+	if err != nil {
+		return duplicate(err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// MarkDeleted hides the account until it is purged.
+func (r *UserRepository) MarkDeleted(ctx context.Context, userID string) error {
+	return r.update(ctx, userID, bson.M{"deleted_at": nil}, bson.M{"deleted_at": time.Now()})
+}
+
+// ListDeleted returns the accounts waiting to be purged.
+func (r *UserRepository) ListDeleted(ctx context.Context) ([]models.User, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{"deleted_at": bson.M{"$ne": nil}})
+	if err != nil {
+		return nil, err
+	}
+	users := []models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Purge removes a deleted account for good.
+func (r *UserRepository) Purge(ctx context.Context, userID string) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrNotFound
+	}
+	_, err = r.collection.DeleteOne(ctx, bson.M{"_id": id, "deleted_at": bson.M{"$ne": nil}})
+	return err
+}
+
+// update sets fields on the user matching userID and filter.
+func (r *UserRepository) update(ctx context.Context, userID string, filter, fields bson.M) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrNotFound
+	}
+	if filter == nil {
+		filter = bson.M{}
+	}
+	filter["_id"] = id
+
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": fields})
+	if err != nil {
+		return duplicate(err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Usernames maps user IDs to usernames in a single query. Unknown IDs are
+// left out.
+func (r *UserRepository) Usernames(ctx context.Context, userIDs []string) (map[string]string, error) {
+	usernames := map[string]string{}
+	var ids []primitive.ObjectID
+	for _, userID := range userIDs {
+		if id, err := primitive.ObjectIDFromHex(userID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return usernames, nil
+	}
+
+	cursor, err := r.collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return nil, err
+		}
+		usernames[user.ID.Hex()] = user.Username
+	}
+	return usernames, cursor.Err()
+}
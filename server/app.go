@@ -0,0 +1,210 @@
+package server
+
+import (
+	"trademarkia/cache"
+	"trademarkia/config"
+	"trademarkia/handlers"
+	"trademarkia/identity"
+	"trademarkia/mail"
+	"trademarkia/middlewares"
+	"trademarkia/models"
+	"trademarkia/ratelimit"
+	"trademarkia/storage"
+	"trademarkia/tokens"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+)
+
+// Dependencies are the external services the application is built on.
+// StartServer fills them with the real databases; tests can use fakes.
+type Dependencies struct {
+	Users         handlers.UserStore
+	RefreshTokens handlers.RefreshTokenStore
+	APIKeys       handlers.APIKeyStore
+	Sessions      handlers.SessionStore
+	Files         handlers.FileStore
+	Shares        handlers.ShareStore
+	Permissions   handlers.PermissionStore
+	Storage       storage.Storage
+	Cache         cache.Cache
+	Limiter       ratelimit.Limiter
+	Keys          *tokens.KeySet
+	Mailer        mail.Mailer
+	// Identity is optional; without it OpenID Connect sign in is off
+	Identity *identity.Provider
+}
+
+// App is the HTTP application with its services and routes wired up.
+type App struct {
+	Fiber   *fiber.App
+	Limiter ratelimit.Limiter
+	Tokens  *tokens.Manager
+	Auth    *handlers.AuthService
+	Files   *handlers.FileService
+	Admin   *handlers.AdminService
+}
+
+// New builds the application on deps and registers every route.
+func New(deps Dependencies) *App {
+	tokenManager := tokens.NewManager(deps.Cache, deps.Keys)
+	a := &App{
+		Fiber: fiber.New(fiber.Config{
+			ErrorHandler: handlers.ErrorHandler,
+		}),
+		Tokens:  tokenManager,
+		Limiter: deps.Limiter,
+		Auth: &handlers.AuthService{
+			Users:         deps.Users,
+			RefreshTokens: deps.RefreshTokens,
+			APIKeys:       deps.APIKeys,
+			Sessions:      deps.Sessions,
+			Tokens:        tokenManager,
+			Mailer:        deps.Mailer,
+			Cache:         deps.Cache,
+			Limiter:       deps.Limiter,
+			Identity:      deps.Identity,
+
+			RequireEmailVerification: config.REQUIRE_EMAIL_VERIFICATION,
+		},
+		Files: &handlers.FileService{
+			Files:       deps.Files,
+			Shares:      deps.Shares,
+			Permissions: deps.Permissions,
+			Users:       deps.Users,
+			Storage:     deps.Storage,
+			Cache:       deps.Cache,
+			Limiter:     deps.Limiter,
+		},
+	}
+	a.Admin = &handlers.AdminService{Auth: a.Auth, Files: a.Files}
+	a.routes()
+	return a
+}
+
+func (a *App) routes() {
+	app := a.Fiber
+	files := a.Files
+
+	app.Use(requestid.New())
+	app.Use(recover.New())
+	app.Use(logger.New(logger.Config{
+		Format: "${time} | ${locals:requestid} | ${status} | ${latency} | ${ip} | ${method} | ${path} | ${error}\n",
+	}))
+	app.Use(cors.New())
+
+	// Public Routes
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"message": "Hello, world!",
+		})
+	})
+	app.Post("/register",
+		middlewares.RateLimit(a.Limiter, "register:ip", config.RATE_LIMIT_REGISTER_IP, middlewares.ByIP),
+		middlewares.RateLimit(a.Limiter, "register:account", config.RATE_LIMIT_REGISTER_ACCOUNT, middlewares.ByEmail),
+		a.Auth.SignupHandler)
+	app.Post("/login",
+		middlewares.RateLimit(a.Limiter, "login:ip", config.RATE_LIMIT_LOGIN_IP, middlewares.ByIP),
+		middlewares.RateLimit(a.Limiter, "login:account", config.RATE_LIMIT_LOGIN_ACCOUNT, middlewares.ByEmail),
+		a.Auth.LoginHandler)
+	app.Post("/login/mfa",
+		middlewares.RateLimit(a.Limiter, "login:ip", config.RATE_LIMIT_LOGIN_IP, middlewares.ByIP),
+		a.Auth.MFALoginHandler)
+	if a.Auth.Identity != nil {
+		app.Get("/auth/oidc/login",
+			middlewares.RateLimit(a.Limiter, "oidc:ip", config.RATE_LIMIT_LOGIN_IP, middlewares.ByIP),
+			a.Auth.OIDCLoginHandler)
+		app.Get("/auth/oidc/callback", a.Auth.OIDCCallbackHandler)
+	}
+	app.Post("/token/refresh", a.Auth.RefreshTokenHandler)
+	app.Get("/.well-known/jwks.json", a.Auth.JWKSHandler)
+	app.Get("/verify-email", a.Auth.VerifyEmailHandler)
+	app.Post("/verify-email", a.Auth.VerifyEmailHandler)
+	// Both send mail to any address they are given, so they are limited per
+	// address as well as per client
+	limitEmailIP := middlewares.RateLimit(a.Limiter, "email:ip", config.RATE_LIMIT_EMAIL_IP, middlewares.ByIP)
+	limitEmailAccount := middlewares.RateLimit(a.Limiter, "email:account", config.RATE_LIMIT_EMAIL_ACCOUNT, middlewares.ByEmail)
+	app.Post("/verify-email/resend", limitEmailIP, limitEmailAccount, a.Auth.ResendVerificationHandler)
+	app.Get("/confirm-email", a.Auth.ConfirmEmailHandler)
+	app.Post("/confirm-email", a.Auth.ConfirmEmailHandler)
+	app.Post("/password/forgot", limitEmailIP, limitEmailAccount, a.Auth.ForgotPasswordHandler)
+	app.Post("/password/reset", a.Auth.ResetPasswordHandler)
+	app.Get("/storage/*", files.PresignedObjectHandler)
+	app.Get("/s/:token",
+		middlewares.RateLimit(a.Limiter, "share:ip", config.RATE_LIMIT_SHARE_ACCESS, middlewares.ByIP),
+		files.PublicShareHandler)
+
+	// Protected Routes. API keys are accepted too, limited to their scopes
+	protected := app.Group("/", middlewares.AuthMiddleware(a.Tokens, a.Auth.APIKeys, a.Auth))
+
+	// Each group of routes has its own per-user rate limit
+	limit := func(group string, rate ratelimit.Rate) fiber.Handler {
+		return middlewares.RateLimit(a.Limiter, "user:"+group, rate, middlewares.ByUser)
+	}
+	limitRead := limit("read", config.RATE_LIMIT_READ)
+	limitUpload := limit("upload", config.RATE_LIMIT_UPLOAD)
+	limitShare := limit("share", config.RATE_LIMIT_SHARE)
+	limitDelete := limit("delete", config.RATE_LIMIT_DELETE)
+	limitAccount := limit("account", config.RATE_LIMIT_ACCOUNT)
+
+	// Managing the account needs a logged in session, not an API key
+	session := middlewares.RequireSession()
+	protected.Post("/logout", session, limitAccount, a.Auth.LogoutHandler)
+	protected.Get("/me", session, limitAccount, a.Auth.GetMeHandler)
+	protected.Patch("/me", session, limitAccount, a.Auth.UpdateMeHandler)
+	protected.Delete("/me", session, limitAccount, a.Auth.DeleteMeHandler)
+	protected.Post("/me/password", session, limitAccount, a.Auth.ChangePasswordHandler)
+	protected.Post("/me/email", session, limitAccount, a.Auth.ChangeEmailHandler)
+	protected.Get("/sessions", session, limitAccount, a.Auth.ListSessionsHandler)
+	protected.Delete("/sessions", session, limitAccount, a.Auth.LogoutAllHandler)
+	protected.Delete("/sessions/:session_id", session, limitAccount, a.Auth.RevokeSessionHandler)
+	protected.Post("/mfa/totp/enroll", session, limitAccount, a.Auth.EnrollTOTPHandler)
+	protected.Post("/mfa/totp/confirm", session, limitAccount, a.Auth.ConfirmTOTPHandler)
+	protected.Post("/mfa/totp/disable", session, limitAccount, a.Auth.DisableTOTPHandler)
+	protected.Post("/api-keys", session, limitAccount, a.Auth.CreateAPIKeyHandler)
+	protected.Get("/api-keys", session, limitAccount, a.Auth.ListAPIKeysHandler)
+	protected.Delete("/api-keys/:key_id", session, limitAccount, a.Auth.RevokeAPIKeyHandler)
+
+	scopeRead := middlewares.RequireScope(models.ScopeRead)
+	scopeUpload := middlewares.RequireScope(models.ScopeUpload)
+	scopeShare := middlewares.RequireScope(models.ScopeShare)
+	scopeDelete := middlewares.RequireScope(models.ScopeDelete)
+
+	protected.Post("/upload", scopeUpload, limitUpload, files.UploadHandler)
+	protected.Get("/files", scopeRead, limitRead, files.GetFilesHandler)
+	protected.Get("/search", scopeRead, limitRead, files.SearchFilesHandler)
+
+	// Routes acting on a single file check the caller's permission on it first
+	read := files.RequireFilePermission(handlers.PermissionRead)
+	edit := files.RequireFilePermission(handlers.PermissionEdit)
+	owner := files.RequireFilePermission(handlers.PermissionOwner)
+	protected.Get("/files/:file_id/content", scopeRead, limitRead, read, files.DownloadFileHandler)
+	protected.Patch("/files/:file_id", scopeUpload, limitUpload, edit, files.UpdateFileMetadataHandler)
+	protected.Delete("/files/:file_id", scopeDelete, limitDelete, owner, files.DeleteFileHandler)
+	protected.Post("/share/:file_id", scopeShare, limitShare, owner, files.ShareFileHandler)
+	// Deprecated: links used to be minted with GET, which older clients still use
+	protected.Get("/share/:file_id", scopeShare, limitShare, owner, files.ShareFileHandler)
+	protected.Get("/share/:file_id/links", scopeShare, limitShare, owner, files.ListShareLinksHandler)
+	protected.Post("/files/:file_id/shares", scopeShare, limitShare, owner, files.CreateShareHandler)
+	protected.Get("/files/:file_id/shares", scopeShare, limitShare, owner, files.ListSharesHandler)
+	protected.Delete("/files/:file_id/shares/:share_id", scopeShare, limitShare, owner, files.RevokeShareHandler)
+	protected.Post("/files/:file_id/permissions", scopeShare, limitShare, owner, files.GrantPermissionHandler)
+	protected.Get("/files/:file_id/permissions", scopeShare, limitShare, owner, files.ListPermissionsHandler)
+	protected.Delete("/files/:file_id/permissions/:user_id", scopeShare, limitShare, owner, files.RevokePermissionHandler)
+
+	protected.Get("/trash", scopeRead, limitRead, files.GetTrashHandler)
+	protected.Post("/trash/:file_id/restore", scopeDelete, limitDelete, files.RequireTrashedFile(), files.RestoreFileHandler)
+
+	// Admin Routes
+	admin := protected.Group("/admin", middlewares.RequireRole(models.RoleAdmin), limit("admin", config.RATE_LIMIT_ADMIN))
+	admin.Get("/users", a.Admin.ListUsersHandler)
+	admin.Get("/users/:user_id/files", a.Admin.UserFilesHandler)
+	admin.Get("/users/:user_id/usage", a.Admin.UserUsageHandler)
+	admin.Put("/users/:user_id/role", a.Admin.SetRoleHandler)
+	admin.Post("/users/:user_id/suspend", a.Admin.SuspendUserHandler)
+	admin.Post("/users/:user_id/unsuspend", a.Admin.UnsuspendUserHandler)
+	admin.Delete("/files/:file_id", a.Admin.ForceDeleteFileHandler)
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"trademarkia/config"
+	"trademarkia/identity"
+	"trademarkia/mail"
+	"trademarkia/repository"
+	"trademarkia/storage"
+	"trademarkia/tokens"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MONGO DB
+func connectToMongoDB() *mongo.Client {
+	connectionURI := config.MONGO_URL
+	clientOptions := options.Client().ApplyURI(connectionURI)
+
+	client, err := mongo.Connect(context.Background(), clientOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println("Connected to MongoDB Atlas!")
+	return client
+}
+
+func disconnectFromMongoDB(client *mongo.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := client.Disconnect(ctx)
+	if err != nil {
+		log.Println("Failed to disconnect from MongoDB:", err)
+		return
+	}
+
+	fmt.Println("Disconnected from MongoDB Atlas!")
+}
+
+// POSTGRES
+func connectToPostgres() *pgxpool.Pool {
+	pool, err := repository.NewPool(context.Background())
+	if err != nil {
+		log.Fatal("Failed to connect to PostgreSQL:", err)
+	}
+
+	err = pool.Ping(context.Background())
+	if err != nil {
+		log.Fatal("Failed to ping PostgreSQL:", err)
+	}
+
+	fmt.Println("Connected to PostgreSQL!")
+	return pool
+}
+
+func disconnectFromPostgres(pool *pgxpool.Pool) {
+	pool.Close()
+
+	fmt.Println("Disconnected from PostgreSQL!")
+}
+
+// REDIS
+func connectToRedis() *redis.Client {
+	client := redis.NewClient(&redis.Options{
+		Addr:     config.REDIS_ADDR, // Use container name and port
+		Password: "",                // No password set
+		DB:       0,                 // Default DB
+	})
+
+	// The client reconnects by itself, and rate limits fall back to memory
+	// in the meantime, so a missing Redis is not fatal
+	_, err := client.Ping(context.Background()).Result()
+	if err != nil {
+		log.Println("Failed to connect to Redis, continuing without it:", err)
+		return client
+	}
+
+	fmt.Println("Connected to Redis!")
+	return client
+}
+
+func disconnectFromRedis(client *redis.Client) {
+	if err := client.Close(); err != nil {
+		log.Println("Failed to disconnect from Redis:", err)
+		return
+	}
+
+	fmt.Println("Disconnected from Redis!")
+}
+
+// FILE STORAGE
+func connectToStorage() storage.Storage {
+	fileStorage, err := storage.New(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Using %s file storage!\n", config.STORAGE_BACKEND)
+	return fileStorage
+}
+
+// SIGNING KEYS
+func loadSigningKeys() *tokens.KeySet {
+	if config.JWT_KEY_DIR == "" {
+		log.Println("JWT_KEY_DIR is not set, signing tokens with an ephemeral key")
+		keys, err := tokens.NewEphemeralKeySet()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return keys
+	}
+
+	keys, err := tokens.LoadKeySet(config.JWT_KEY_DIR)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go keys.ReloadEvery(config.JWT_KEY_RELOAD_INTERVAL)
+
+	fmt.Printf("Loaded signing keys from %s!\n", config.JWT_KEY_DIR)
+	return keys
+}
+
+// MAIL
+func connectToMailer() mail.Mailer {
+	mailer, err := mail.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Sending mail with the %s backend!\n", config.MAIL_BACKEND)
+	return mailer
+}
+
+// IDENTITY PROVIDER
+func connectToIdentityProvider() *identity.Provider {
+	if config.OIDC_ISSUER_URL == "" {
+		return nil
+	}
+
+	provider, err := identity.NewProvider(context.Background(), config.OIDC_ISSUER_URL,
+		config.OIDC_CLIENT_ID, config.OIDC_CLIENT_SECRET, config.OIDC_REDIRECT_URL, strings.Fields(config.OIDC_SCOPES))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Signing in with %s!\n", config.OIDC_ISSUER_URL)
+	return provider
+}
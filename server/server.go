@@ -1,19 +1,22 @@
 package server
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
-	"trademarkia/handlers"
+	"trademarkia/cache"
 	"trademarkia/jobs"
-	"trademarkia/middlewares"
+	"trademarkia/migrations"
+	"trademarkia/models"
+	"trademarkia/ratelimit"
+	"trademarkia/repository"
 
 	"trademarkia/config"
 
-	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/joho/godotenv"
 )
 
@@ -23,42 +26,77 @@ func StartServer() {
 		log.Fatal(err)
 	}
 	// Connections
-	handlers.ConnectToPostgres()
-	handlers.ConnectToMongoDB()
-	handlers.ConnectToS3()
-
-	// Defer disconnecting from the connections when shutdown
-	defer handlers.DisconnectFromMongoDB()
-	defer handlers.DisconnectFromPostgres()
-	defer handlers.DisconnectFromS3()
+	postgresPool := connectToPostgres()
+	if config.AUTO_MIGRATE {
+		applied, err := migrations.Up(context.Background(), postgresPool)
+		if err != nil {
+			log.Fatal("Failed to migrate database:", err)
+		}
+		log.Printf("Applied %d migration(s)", applied)
+	}
+	mongoClient := connectToMongoDB()
+	redisClient := connectToRedis()
+	fileStorage := connectToStorage()
 
-	go jobs.StartFileDeletionJob(handlers.PostgresDB, handlers.S3Client)
+	// Disconnect from the connections when shutdown
+	defer disconnectFromMongoDB(mongoClient)
+	defer disconnectFromPostgres(postgresPool)
+	defer disconnectFromRedis(redisClient)
 
-	PORT := config.PORT
-	app := fiber.New()
+	mongoDB := mongoClient.Database("Trademarkia")
+	refreshTokens := repository.NewRefreshTokenRepository(mongoDB)
+	if err := refreshTokens.EnsureIndexes(context.Background()); err != nil {
+		log.Fatal("Failed to create MongoDB indexes:", err)
+	}
+	apiKeys := repository.NewAPIKeyRepository(mongoDB)
+	if err := apiKeys.EnsureIndexes(context.Background()); err != nil {
+		log.Fatal("Failed to create MongoDB indexes:", err)
+	}
 
-	app.Use(logger.New())
-	app.Use(cors.New())
+	sessions := repository.NewSessionRepository(mongoDB)
+	if err := sessions.EnsureIndexes(context.Background()); err != nil {
+		log.Fatal("Failed to create MongoDB indexes:", err)
+	}
 
-	// Public Routes
-	app.Get("/", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{
-			"message": "Hello, world!",
-		})
+	users := repository.NewUserRepository(mongoDB)
+	// Accounts made before the indexes existed may share an email address
+	// or username. Signing up still checks for both, so the server starts
+	// and the duplicates can be sorted out while it runs.
+	err = users.EnsureIndexes(context.Background())
+	if err == repository.ErrDuplicate {
+		log.Println("Email addresses and usernames are not unique yet, as some accounts share them; run `./main duplicates` to list them")
+	} else if err != nil {
+		log.Fatal("Failed to create MongoDB indexes:", err)
+	}
+	files := repository.NewFileRepository(postgresPool)
+	permissions := repository.NewPermissionRepository(postgresPool)
+	app := New(Dependencies{
+		Users:         users,
+		RefreshTokens: refreshTokens,
+		APIKeys:       apiKeys,
+		Sessions:      sessions,
+		Files:         files,
+		Shares:        repository.NewShareRepository(postgresPool),
+		Permissions:   permissions,
+		Storage:       fileStorage,
+		Cache:         cache.NewRedisCache(redisClient),
+		Limiter:       ratelimit.NewFallback(ratelimit.NewRedisLimiter(redisClient), ratelimit.NewMemoryLimiter()),
+		Keys:          loadSigningKeys(),
+		Identity:      connectToIdentityProvider(),
+		Mailer:        connectToMailer(),
 	})
-	app.Post("/register", handlers.SignupHandler)
-	app.Post("/login", handlers.LoginHandler)
 
-	// Protected Routes
-	protected := app.Group("/", middlewares.AuthMiddleware)
-
-	protected.Post("/upload", handlers.UploadHandler)
-	protected.Get("/files", handlers.GetFilesHandler)
-	protected.Get("/share/:file_id", handlers.ShareFileHandler)
-	protected.Get("/search", handlers.SearchFilesHandler)
+	jobs.StartFileDeletionJob(&jobs.FileDeleter{Files: files, Storage: fileStorage})
+	jobs.StartAccountPurgeJob(&jobs.AccountPurger{
+		Users:    users,
+		Files:    files,
+		UserData: []jobs.UserDataStore{permissions, apiKeys, sessions, refreshTokens},
+		Storage:  fileStorage,
+	})
 
+	PORT := config.PORT
 	go func() {
-		err := app.Listen(":" + PORT)
+		err := app.Fiber.Listen(":" + PORT)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -68,8 +106,64 @@ func StartServer() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	// Disconnect from the connections
-	handlers.DisconnectFromMongoDB()
-	handlers.DisconnectFromPostgres()
-	handlers.DisconnectFromS3()
+	if err := app.Fiber.Shutdown(); err != nil {
+		log.Println("Failed to shut down server:", err)
+	}
+}
+
+// Migrate runs the migrate sub-command, e.g. "migrate up".
+func Migrate(args []string) {
+	pool := connectToPostgres()
+	defer disconnectFromPostgres(pool)
+
+	if err := migrations.Command(context.Background(), pool, args, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Duplicates lists the email addresses and usernames shared by several
+// accounts, which keep them from being made unique.
+func Duplicates(args []string) {
+	if len(args) != 0 {
+		log.Fatal("usage: duplicates")
+	}
+
+	mongoClient := connectToMongoDB()
+	defer disconnectFromMongoDB(mongoClient)
+
+	users := repository.NewUserRepository(mongoClient.Database("Trademarkia"))
+	duplicates, err := users.FindDuplicates(context.Background())
+	if err != nil {
+		log.Fatal("Failed to find duplicates: ", err)
+	}
+	if len(duplicates) == 0 {
+		fmt.Println("No accounts share an email address or username")
+		return
+	}
+	for _, duplicate := range duplicates {
+		fmt.Printf("%s %s: %s\n", duplicate.Field, duplicate.Value, strings.Join(duplicate.UserIDs, ", "))
+	}
+	os.Exit(1)
+}
+
+// SetRole gives the user with the given email or username a role. It is how
+// the first admin is made.
+func SetRole(args []string) {
+	if len(args) != 2 || (args[1] != models.RoleUser && args[1] != models.RoleAdmin) {
+		log.Fatal("usage: role <email or username> <user|admin>")
+	}
+
+	mongoClient := connectToMongoDB()
+	defer disconnectFromMongoDB(mongoClient)
+
+	ctx := context.Background()
+	users := repository.NewUserRepository(mongoClient.Database("Trademarkia"))
+	user, err := users.GetByLogin(ctx, args[0])
+	if err != nil {
+		log.Fatal("Failed to find user: ", err)
+	}
+	if err := users.SetRole(ctx, user.ID.Hex(), args[1]); err != nil {
+		log.Fatal("Failed to set role: ", err)
+	}
+	log.Printf("%s is now %s", user.Username, args[1])
 }
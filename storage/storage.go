@@ -0,0 +1,69 @@
+// Package storage abstracts where uploaded file contents are kept so the rest of
+// the service does not depend on S3 directly.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"trademarkia/config"
+)
+
+// ErrNotFound is returned when an object does not exist in the backend.
+var ErrNotFound = errors.New("storage: object not found")
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+	Metadata     map[string]string
+}
+
+// PutOptions carries optional attributes that are stored alongside an object.
+type PutOptions struct {
+	ContentType string
+	Metadata    map[string]string
+}
+
+// Storage is implemented by every object storage backend.
+type Storage interface {
+	// Put stores the contents of r under key, replacing any existing object.
+	Put(ctx context.Context, key string, r io.Reader, opts PutOptions) error
+	// Get opens the object for reading. The caller must close the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	// GetRange opens length bytes of the object starting at offset. The
+	// returned ObjectInfo still reports the size of the whole object.
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, *ObjectInfo, error)
+	// Delete removes the object. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+	// Stat returns the object's attributes without reading its contents.
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// List returns every object whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// PresignGet returns a URL that allows anyone holding it to download the
+	// object until ttl elapses.
+	PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error)
+	// URL returns the canonical, non-expiring location of the object.
+	URL(key string) string
+}
+
+// New builds the backend selected by config.STORAGE_BACKEND.
+func New(ctx context.Context) (Storage, error) {
+	signer := NewURLSigner(config.PUBLIC_URL, config.SECRET_KEY)
+
+	switch config.STORAGE_BACKEND {
+	case "", "s3":
+		return NewS3Storage(ctx, config.S3_BUCKET, config.AWS_REGION)
+	case "local":
+		return NewLocalStorage(config.STORAGE_LOCAL_DIR, signer)
+	case "memory":
+		return NewMemoryStorage(signer), nil
+	default:
+		return nil, fmt.Errorf("storage: unknown backend %q", config.STORAGE_BACKEND)
+	}
+}
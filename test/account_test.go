@@ -0,0 +1,106 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"trademarkia/mail"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var mailTokenPattern = regexp.MustCompile(`token=(\S+)`)
+
+func TestEmailVerificationAndPasswordReset(t *testing.T) {
+	app, _, _ := newTestApp()
+	app.Auth.RequireEmailVerification = true
+	mailer := app.Auth.Mailer.(*fakeMailer)
+
+	do := func(method, path, body string) *http.Response {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Fiber.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+	// Some mail is sent in the background, so wait for it
+	mailedToken := func(to, subject string) string {
+		var msg mail.Message
+		require.Eventually(t, func() bool {
+			var ok bool
+			msg, ok = mailer.last(to)
+			return ok && msg.Subject == subject
+		}, time.Second, 10*time.Millisecond, "no %q mail sent to %s", subject, to)
+		match := mailTokenPattern.FindStringSubmatch(msg.Body)
+		require.NotNil(t, match)
+		token, err := url.QueryUnescape(match[1])
+		require.NoError(t, err)
+		return token
+	}
+	login := func(password string) *http.Response {
+		return do(http.MethodPost, "/login", `{"email":"alice@example.com","password":"`+password+`"}`)
+	}
+
+	resp := do(http.MethodPost, "/register", `{"email":"alice@example.com","username":"alice","password":"secret-123"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	verifyToken := mailedToken("alice@example.com", "Verify your email address")
+
+	t.Run("verify email", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, login("secret-123").StatusCode)
+
+		// A verification token cannot reset the password
+		resp := do(http.MethodPost, "/password/reset", `{"token":"`+verifyToken+`","password":"hijacked"}`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = do(http.MethodGet, "/verify-email?token="+url.QueryEscape(verifyToken), "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, http.StatusOK, login("secret-123").StatusCode)
+
+		resp = do(http.MethodPost, "/verify-email", `{"token":"not-a-token"}`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("password reset", func(t *testing.T) {
+		session := decode(t, login("secret-123"))
+
+		// Unknown addresses get the same answer and no mail
+		resp := do(http.MethodPost, "/password/forgot", `{"email":"bob@example.com"}`)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = do(http.MethodPost, "/password/forgot", `{"email":"alice@example.com"}`)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		resetToken := mailedToken("alice@example.com", "Reset your password")
+		_, ok := mailer.last("bob@example.com")
+		assert.False(t, ok)
+
+		resp = do(http.MethodPost, "/password/reset", `{"token":"`+resetToken+`","password":"new-secret"}`)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		assert.Equal(t, http.StatusUnauthorized, login("secret-123").StatusCode)
+		assert.Equal(t, http.StatusOK, login("new-secret").StatusCode)
+
+		// Existing sessions are logged out
+		resp = do(http.MethodPost, "/token/refresh", `{"refresh_token":"`+session.RefreshToken+`"}`)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		// Reset links work once
+		resp = do(http.MethodPost, "/password/reset", `{"token":"`+resetToken+`","password":"again"}`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("rate limited per address", func(t *testing.T) {
+		// Three requests an hour for one address, wherever they come from
+		for i := 0; i < 2; i++ {
+			assert.Equal(t, http.StatusOK, do(http.MethodPost, "/password/forgot", `{"email":"carol@example.com"}`).StatusCode)
+		}
+		assert.Equal(t, http.StatusOK, do(http.MethodPost, "/verify-email/resend", `{"email":"carol@example.com"}`).StatusCode)
+		resp := do(http.MethodPost, "/password/forgot", `{"email":"carol@example.com"}`)
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.Equal(t, http.StatusOK, do(http.MethodPost, "/password/forgot", `{"email":"dave@example.com"}`).StatusCode)
+	})
+}
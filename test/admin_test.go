@@ -0,0 +1,158 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"trademarkia/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminAPI(t *testing.T) {
+	app, users, _ := newTestApp()
+
+	do := func(method, path, token, body string) *http.Response {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if strings.HasPrefix(token, "tmk_") {
+			req.Header.Set("X-API-Key", token)
+		} else if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := app.Fiber.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+	read := func(resp *http.Response, v interface{}) {
+		t.Helper()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+	login := func(email string) *http.Response {
+		return do(http.MethodPost, "/login", "", `{"email":"`+email+`","password":"secret-123"}`)
+	}
+
+	for _, name := range []string{"alice", "bob"} {
+		resp := do(http.MethodPost, "/register", "", `{"email":"`+name+`@example.com","username":"`+name+`","password":"secret-123"}`)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	alice, err := users.GetByEmail(context.Background(), "alice@example.com")
+	require.NoError(t, err)
+	bob, err := users.GetByEmail(context.Background(), "bob@example.com")
+	require.NoError(t, err)
+	bobID := bob.ID.Hex()
+
+	bobSession := decode(t, login("bob@example.com"))
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/admin/users", bobSession.Token, "").StatusCode)
+
+	// The role is carried in the token, so it takes a new login to use it
+	require.NoError(t, users.SetRole(context.Background(), alice.ID.Hex(), models.RoleAdmin))
+	admin := decode(t, login("alice@example.com")).Token
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", "report.txt")
+	require.NoError(t, err)
+	part.Write([]byte("quarterly numbers"))
+	writer.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+bobSession.Token)
+	resp, err := app.Fiber.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	t.Run("users, files and usage", func(t *testing.T) {
+		var listed struct {
+			Users []models.User `json:"users"`
+		}
+		read(do(http.MethodGet, "/admin/users", admin, ""), &listed)
+		require.Len(t, listed.Users, 2)
+		assert.Equal(t, models.RoleAdmin, listed.Users[0].Role)
+
+		var files struct {
+			Files []models.File `json:"files"`
+		}
+		read(do(http.MethodGet, "/admin/users/"+bobID+"/files", admin, ""), &files)
+		require.Len(t, files.Files, 1)
+
+		var usage struct {
+			Files int   `json:"files"`
+			Bytes int64 `json:"bytes"`
+		}
+		read(do(http.MethodGet, "/admin/users/"+bobID+"/usage", admin, ""), &usage)
+		assert.Equal(t, 1, usage.Files)
+		assert.Equal(t, int64(len("quarterly numbers")), usage.Bytes)
+
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/admin/users/000000000000000000000000/usage", admin, "").StatusCode)
+	})
+
+	t.Run("suspension", func(t *testing.T) {
+		var created struct {
+			Key string `json:"key"`
+		}
+		resp := do(http.MethodPost, "/api-keys", bobSession.Token, `{"name":"ci"}`)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+
+		resp = do(http.MethodPost, "/admin/users/"+bobID+"/suspend", admin, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		assert.Equal(t, http.StatusForbidden, login("bob@example.com").StatusCode)
+		assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/files", created.Key, "").StatusCode)
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/files", bobSession.Token, "").StatusCode)
+
+		resp = do(http.MethodPost, "/admin/users/"+bobID+"/unsuspend", admin, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/files", created.Key, "").StatusCode)
+		bobSession = decode(t, login("bob@example.com"))
+
+		// Admins cannot lock themselves out, and API keys cannot use the admin API
+		resp = do(http.MethodPost, "/admin/users/"+alice.ID.Hex()+"/suspend", admin, "")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/admin/users", created.Key, "").StatusCode)
+	})
+
+	t.Run("force delete", func(t *testing.T) {
+		var files []models.File
+		read(do(http.MethodGet, "/files", bobSession.Token, ""), &files)
+		require.Len(t, files, 1)
+
+		fileID := files[0].FileID
+		resp := do(http.MethodDelete, "/admin/files/"+fileID, admin, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		read(do(http.MethodGet, "/files", bobSession.Token, ""), &files)
+		assert.Empty(t, files)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/admin/files/"+fileID, admin, "").StatusCode)
+	})
+
+	t.Run("demotion logs out", func(t *testing.T) {
+		setRole := func(role string) {
+			resp := do(http.MethodPut, "/admin/users/"+bobID+"/role", admin, `{"role":"`+role+`"}`)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+
+		resp := do(http.MethodPut, "/admin/users/"+bobID+"/role", admin, `{"role":"owner"}`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		// Promotion keeps existing sessions; the new role needs a new token
+		setRole(models.RoleAdmin)
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/files", bobSession.Token, "").StatusCode)
+		bobAdmin := decode(t, login("bob@example.com")).Token
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/admin/users", bobAdmin, "").StatusCode)
+
+		setRole(models.RoleUser)
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/admin/users", bobAdmin, "").StatusCode)
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/files", bobSession.Token, "").StatusCode)
+		bobSession = decode(t, login("bob@example.com"))
+		assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/admin/users", bobSession.Token, "").StatusCode)
+	})
+}
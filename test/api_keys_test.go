@@ -0,0 +1,115 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeys(t *testing.T) {
+	app, _, _ := newTestApp()
+
+	do := func(req *http.Request) *http.Response {
+		resp, err := app.Fiber.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+	jsonRequest := func(method, path, token, body string) *http.Request {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return req
+	}
+	upload := func(header, value string) *http.Response {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, err := writer.CreateFormFile("file", "build.log")
+		require.NoError(t, err)
+		part.Write([]byte("ok"))
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set(header, value)
+		return do(req)
+	}
+	type createdKey struct {
+		Key    string `json:"key"`
+		APIKey struct {
+			ID     string   `json:"id"`
+			Prefix string   `json:"prefix"`
+			Scopes []string `json:"scopes"`
+		} `json:"api_key"`
+	}
+	create := func(token, body string) createdKey {
+		resp := do(jsonRequest(http.MethodPost, "/api-keys", token, body))
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		var created createdKey
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+		return created
+	}
+
+	resp := do(jsonRequest(http.MethodPost, "/register", "", `{"email":"ci@example.com","username":"ci-bot","password":"secret-123"}`))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	session := decode(t, do(jsonRequest(http.MethodPost, "/login", "", `{"email":"ci@example.com","password":"secret-123"}`)))
+
+	uploadKey := create(session.Token, `{"name":"ci","scopes":["upload"]}`)
+	assert.True(t, strings.HasPrefix(uploadKey.Key, uploadKey.APIKey.Prefix))
+	assert.Equal(t, []string{"upload"}, uploadKey.APIKey.Scopes)
+
+	t.Run("scopes are enforced", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, upload("X-API-Key", uploadKey.Key).StatusCode)
+		assert.Equal(t, http.StatusOK, upload("Authorization", "ApiKey "+uploadKey.Key).StatusCode)
+
+		req := httptest.NewRequest(http.MethodGet, "/files", nil)
+		req.Header.Set("X-API-Key", uploadKey.Key)
+		assert.Equal(t, http.StatusForbidden, do(req).StatusCode)
+
+		// Keys cannot manage the account, including other keys
+		req = httptest.NewRequest(http.MethodGet, "/api-keys", nil)
+		req.Header.Set("X-API-Key", uploadKey.Key)
+		assert.Equal(t, http.StatusForbidden, do(req).StatusCode)
+
+		fullKey := create(session.Token, `{"name":"full"}`)
+		assert.Equal(t, []string{"read", "upload", "share", "delete"}, fullKey.APIKey.Scopes)
+		req = httptest.NewRequest(http.MethodGet, "/files", nil)
+		req.Header.Set("X-API-Key", fullKey.Key)
+		assert.Equal(t, http.StatusOK, do(req).StatusCode)
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, upload("X-API-Key", "tmk_nope").StatusCode)
+
+		resp := do(jsonRequest(http.MethodPost, "/api-keys", session.Token, `{"name":"bad","scopes":["admin"]}`))
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp = do(jsonRequest(http.MethodPost, "/api-keys", session.Token, `{"name":"old","expires_at":"2020-01-01T00:00:00Z"}`))
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("list and revoke", func(t *testing.T) {
+		var listed struct {
+			APIKeys []map[string]interface{} `json:"api_keys"`
+		}
+		resp := do(jsonRequest(http.MethodGet, "/api-keys", session.Token, ""))
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&listed))
+		require.Len(t, listed.APIKeys, 2)
+		assert.NotContains(t, listed.APIKeys[0], "key_hash")
+
+		resp = do(jsonRequest(http.MethodDelete, "/api-keys/"+uploadKey.APIKey.ID, session.Token, ""))
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, http.StatusUnauthorized, upload("X-API-Key", uploadKey.Key).StatusCode)
+
+		resp = do(jsonRequest(http.MethodDelete, "/api-keys/"+uploadKey.APIKey.ID, session.Token, ""))
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
@@ -0,0 +1,87 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"trademarkia/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAppRoutes drives the fully wired application: sign up, log in and use
+// the token on the protected routes.
+func TestAppRoutes(t *testing.T) {
+	app, users, _ := newTestApp()
+
+	post := func(path, body string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Fiber.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := post("/register", `{"email":"alice@example.com","username":"alice","password":"secret-123"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, users.users, 1)
+
+	resp = post("/register", `{"email":"alice@example.com","username":"alice2","password":"secret-123"}`)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp = post("/login", `{"email":"alice@example.com","password":"wrong"}`)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// An unknown email is refused like a wrong password instead of crashing
+	resp = post("/login", `{"email":"bob@example.com","password":"secret-123"}`)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = post("/login", `{"email":"alice@example.com","password":"secret-123"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var login struct {
+		Token string `json:"token"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&login))
+	require.NotEmpty(t, login.Token)
+
+	// Protected routes reject anonymous requests
+	resp, err := app.Fiber.Test(httptest.NewRequest(http.MethodGet, "/files", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", "notes.txt")
+	require.NoError(t, err)
+	part.Write([]byte("hello"))
+	writer.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+login.Token)
+	resp, err = app.Fiber.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	req = httptest.NewRequest(http.MethodGet, "/files", nil)
+	req.Header.Set("Authorization", "Bearer "+login.Token)
+	resp, err = app.Fiber.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var files []models.File
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&files))
+	require.Len(t, files, 1)
+	assert.Equal(t, "notes.txt", files[0].Filename)
+	assert.Equal(t, users.users[0].ID.Hex(), files[0].UserID)
+
+	// Downloading goes through the permission check and storage
+	req = httptest.NewRequest(http.MethodGet, "/files/"+files[0].FileID+"/content", nil)
+	req.Header.Set("Authorization", "Bearer "+login.Token)
+	resp, err = app.Fiber.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
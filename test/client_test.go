@@ -0,0 +1,73 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"trademarkia/models"
+	"trademarkia/server"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testClient sends requests to an app built on the fakes.
+type testClient struct {
+	t   *testing.T
+	app *server.App
+}
+
+// do sends a JSON request, authenticated when token is set. headers are
+// extra name, value pairs.
+func (c testClient) do(method, path, token, body string, headers ...string) *http.Response {
+	c.t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := c.app.Fiber.Test(req)
+	require.NoError(c.t, err)
+	return resp
+}
+
+// read decodes a successful JSON response into v.
+func (c testClient) read(resp *http.Response, v interface{}) {
+	c.t.Helper()
+	require.Equal(c.t, http.StatusOK, resp.StatusCode)
+	require.NoError(c.t, json.NewDecoder(resp.Body).Decode(v))
+}
+
+// signup registers name@example.com and returns an access token for it.
+func (c testClient) signup(name string) string {
+	c.t.Helper()
+	resp := c.do(http.MethodPost, "/register", "", `{"email":"`+name+`@example.com","username":"`+name+`","password":"secret-123"}`)
+	require.Equal(c.t, http.StatusOK, resp.StatusCode)
+	return decode(c.t, c.do(http.MethodPost, "/login", "", `{"email":"`+name+`@example.com","password":"secret-123"}`)).Token
+}
+
+// upload uploads content as filename and returns the new file's ID.
+func (c testClient) upload(token, filename, content string) string {
+	c.t.Helper()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", filename)
+	require.NoError(c.t, err)
+	part.Write([]byte(content))
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := c.app.Fiber.Test(req)
+	require.NoError(c.t, err)
+	var uploaded models.File
+	c.read(resp, &uploaded)
+	return uploaded.FileID
+}
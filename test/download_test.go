@@ -0,0 +1,90 @@
+package test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadRanges(t *testing.T) {
+	app, _, _ := newTestApp()
+	client := testClient{t: t, app: app}
+	alice := client.signup("alice")
+	content := "0123456789abcdefghij"
+	path := "/files/" + client.upload(alice, "digits.txt", content) + "/content"
+
+	full := client.do(http.MethodGet, path, alice, "")
+	require.Equal(t, http.StatusOK, full.StatusCode)
+	etag := full.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+	assert.Equal(t, "bytes", full.Header.Get("Accept-Ranges"))
+
+	body := func(resp *http.Response) string {
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(data)
+	}
+	assert.Equal(t, content, body(full))
+
+	t.Run("partial content", func(t *testing.T) {
+		tests := []struct {
+			header, contentRange, body string
+		}{
+			{"bytes=0-4", "bytes 0-4/20", "01234"},
+			{"bytes=10-", "bytes 10-19/20", "abcdefghij"},
+			{"bytes=-3", "bytes 17-19/20", "hij"},
+			// Ends past the object are cut short
+			{"bytes=15-100", "bytes 15-19/20", "fghij"},
+			{"bytes=-100", "", content},
+		}
+		for _, test := range tests {
+			resp := client.do(http.MethodGet, path, alice, "", "Range", test.header)
+			if test.contentRange == "" {
+				assert.Equal(t, http.StatusOK, resp.StatusCode, test.header)
+			} else {
+				assert.Equal(t, http.StatusPartialContent, resp.StatusCode, test.header)
+				assert.Equal(t, test.contentRange, resp.Header.Get("Content-Range"), test.header)
+			}
+			assert.Equal(t, test.body, body(resp), test.header)
+		}
+	})
+
+	t.Run("whole object for ranges it cannot serve", func(t *testing.T) {
+		for _, header := range []string{"bytes=0-1,4-5", "bytes=5-2", "bytes=x-3", "items=0-3", "bytes=3"} {
+			resp := client.do(http.MethodGet, path, alice, "", "Range", header)
+			assert.Equal(t, http.StatusOK, resp.StatusCode, header)
+			assert.Equal(t, content, body(resp), header)
+		}
+	})
+
+	t.Run("unsatisfiable", func(t *testing.T) {
+		for _, header := range []string{"bytes=20-", "bytes=100-200", "bytes=-0"} {
+			resp := client.do(http.MethodGet, path, alice, "", "Range", header)
+			assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.StatusCode, header)
+			assert.Equal(t, "bytes */20", resp.Header.Get("Content-Range"), header)
+		}
+	})
+
+	t.Run("if-range", func(t *testing.T) {
+		resp := client.do(http.MethodGet, path, alice, "", "Range", "bytes=0-4", "If-Range", etag)
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+
+		// A stale validator gets the whole, current object
+		resp = client.do(http.MethodGet, path, alice, "", "Range", "bytes=0-4", "If-Range", `"stale"`)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, content, body(resp))
+	})
+
+	t.Run("not modified", func(t *testing.T) {
+		for _, header := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+			resp := client.do(http.MethodGet, path, alice, "", "If-None-Match", header)
+			assert.Equal(t, http.StatusNotModified, resp.StatusCode, header)
+			assert.Empty(t, body(resp), header)
+		}
+		resp := client.do(http.MethodGet, path, alice, "", "If-None-Match", `"other"`)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
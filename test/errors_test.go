@@ -0,0 +1,62 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"trademarkia/apperr"
+	"trademarkia/handlers"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorHandler(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: handlers.ErrorHandler})
+	app.Use(requestid.New())
+	app.Get("/missing", func(c *fiber.Ctx) error {
+		return apperr.NotFound("File not found")
+	})
+	app.Get("/broken", func(c *fiber.Ctx) error {
+		return apperr.Internal("Database query error", errors.New("connection refused"))
+	})
+	app.Get("/unexpected", func(c *fiber.Ctx) error {
+		return errors.New("secret detail")
+	})
+
+	tests := []struct {
+		path    string
+		status  int
+		code    string
+		message string
+	}{
+		{"/missing", http.StatusNotFound, "not_found", "File not found"},
+		{"/broken", http.StatusInternalServerError, "internal_error", "Database query error"},
+		{"/unexpected", http.StatusInternalServerError, "internal_error", "Internal server error"},
+		{"/no-such-route", http.StatusNotFound, "not_found", "Cannot GET /no-such-route"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, tt.path, nil))
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+
+			var body struct {
+				Error struct {
+					Code      string `json:"code"`
+					Message   string `json:"message"`
+					RequestID string `json:"request_id"`
+				} `json:"error"`
+			}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			assert.Equal(t, tt.code, body.Error.Code)
+			assert.Equal(t, tt.message, body.Error.Message)
+			assert.Equal(t, resp.Header.Get(fiber.HeaderXRequestID), body.Error.RequestID)
+			assert.NotEmpty(t, body.Error.RequestID)
+		})
+	}
+}
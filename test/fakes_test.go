@@ -0,0 +1,868 @@
+package test
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"trademarkia/cache"
+	"trademarkia/mail"
+	"trademarkia/models"
+	"trademarkia/ratelimit"
+	"trademarkia/repository"
+	"trademarkia/server"
+	"trademarkia/storage"
+	"trademarkia/tokens"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newTestApp builds the application on in-memory fakes, so the handlers can
+// be exercised without MongoDB, Postgres or Redis.
+func newTestApp() (*server.App, *fakeUsers, *fakeFiles) {
+	return newTestAppWith(nil)
+}
+
+// newTestAppWith is newTestApp with a chance to change the dependencies
+// first.
+func newTestAppWith(configure func(*server.Dependencies)) (*server.App, *fakeUsers, *fakeFiles) {
+	keys, err := tokens.NewEphemeralKeySet()
+	if err != nil {
+		panic(err)
+	}
+	users := &fakeUsers{}
+	files := &fakeFiles{files: map[string]*models.File{}}
+	deps := server.Dependencies{
+		Users:         users,
+		RefreshTokens: &fakeRefreshTokens{},
+		APIKeys:       &fakeAPIKeys{},
+		Sessions:      &fakeSessions{},
+		Files:         files,
+		Shares:        &fakeShares{files: files},
+		Permissions:   &fakePermissions{files: files},
+		Storage:       storage.NewMemoryStorage(storage.NewURLSigner("http://localhost:8000", "test-secret")),
+		Cache:         cache.NewMemoryCache(),
+		Limiter:       ratelimit.NewMemoryLimiter(),
+		Keys:          keys,
+		Mailer:        &fakeMailer{},
+	}
+	if configure != nil {
+		configure(&deps)
+	}
+	return server.New(deps), users, files
+}
+
+type fakeUsers struct {
+	mu    sync.Mutex
+	users []models.User
+}
+
+func (f *fakeUsers) find(match func(models.User) bool) (*models.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, user := range f.users {
+		if user.DeletedAt == nil && match(user) {
+			return &user, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+// taken mirrors the unique indexes: it reports whether an account other than
+// userID, deleted or not, uses email or username. Callers hold f.mu.
+func (f *fakeUsers) taken(userID, email, username string) bool {
+	for _, user := range f.users {
+		if user.ID.Hex() != userID && ((email != "" && user.Email == email) || (username != "" && user.Username == username)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeUsers) Create(ctx context.Context, user *models.User) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.taken("", user.Email, user.Username) {
+		return repository.ErrDuplicate
+	}
+	user.ID = primitive.NewObjectID()
+	f.users = append(f.users, *user)
+	return nil
+}
+
+func (f *fakeUsers) GetByID(ctx context.Context, userID string) (*models.User, error) {
+	return f.find(func(u models.User) bool { return u.ID.Hex() == userID })
+}
+
+func (f *fakeUsers) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return f.find(func(u models.User) bool { return u.Email == email })
+}
+
+func (f *fakeUsers) GetByLogin(ctx context.Context, login string) (*models.User, error) {
+	return f.find(func(u models.User) bool { return u.Email == login || u.Username == login })
+}
+
+func (f *fakeUsers) EmailExists(ctx context.Context, email string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.taken("", email, ""), nil
+}
+
+func (f *fakeUsers) UsernameExists(ctx context.Context, username string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.taken("", "", username), nil
+}
+
+func (f *fakeUsers) update(userID string, apply func(*models.User) bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.users {
+		if f.users[i].ID.Hex() == userID && apply(&f.users[i]) {
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+func (f *fakeUsers) MarkEmailVerified(ctx context.Context, userID, email string) error {
+	return f.update(userID, func(u *models.User) bool {
+		if u.Email != email {
+			return false
+		}
+		u.EmailVerified = true
+		return true
+	})
+}
+
+func (f *fakeUsers) UpdatePassword(ctx context.Context, userID, passwordHash string) error {
+	return f.update(userID, func(u *models.User) bool {
+		u.Password = passwordHash
+		return true
+	})
+}
+
+func (f *fakeUsers) SetTOTPSecret(ctx context.Context, userID, secret string) error {
+	return f.update(userID, func(u *models.User) bool {
+		if u.MFAEnabled {
+			return false
+		}
+		u.TOTPSecret = secret
+		return true
+	})
+}
+
+func (f *fakeUsers) EnableMFA(ctx context.Context, userID string, recoveryCodeHashes []string) error {
+	return f.update(userID, func(u *models.User) bool {
+		u.MFAEnabled = true
+		u.RecoveryCodes = recoveryCodeHashes
+		return true
+	})
+}
+
+func (f *fakeUsers) DisableMFA(ctx context.Context, userID string) error {
+	return f.update(userID, func(u *models.User) bool {
+		u.MFAEnabled = false
+		u.TOTPSecret = ""
+		u.RecoveryCodes = nil
+		return true
+	})
+}
+
+func (f *fakeUsers) UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error) {
+	used := false
+	err := f.update(userID, func(u *models.User) bool {
+		for i, hash := range u.RecoveryCodes {
+			if hash == codeHash {
+				u.RecoveryCodes = append(u.RecoveryCodes[:i:i], u.RecoveryCodes[i+1:]...)
+				used = true
+				break
+			}
+		}
+		return true
+	})
+	return used, err
+}
+
+func (f *fakeUsers) List(ctx context.Context, limit, offset int) ([]models.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	users := []models.User{}
+	for _, user := range f.users {
+		if user.DeletedAt == nil {
+			users = append(users, user)
+		}
+	}
+	users = users[min(offset, len(users)):]
+	if limit > 0 && limit < len(users) {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (f *fakeUsers) SetRole(ctx context.Context, userID, role string) error {
+	return f.update(userID, func(u *models.User) bool {
+		u.Role = role
+		return true
+	})
+}
+
+func (f *fakeUsers) SetSuspended(ctx context.Context, userID string, suspended bool) error {
+	return f.update(userID, func(u *models.User) bool {
+		u.Suspended = suspended
+		return true
+	})
+}
+
+func (f *fakeUsers) GetByIdentity(ctx context.Context, identity models.Identity) (*models.User, error) {
+	return f.find(func(u models.User) bool {
+		for _, linked := range u.Identities {
+			if linked == identity {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (f *fakeUsers) LinkIdentity(ctx context.Context, userID string, identity models.Identity) error {
+	return f.update(userID, func(u *models.User) bool {
+		u.Identities = append(u.Identities, identity)
+		return true
+	})
+}
+
+func (f *fakeUsers) UpdateProfile(ctx context.Context, userID string, update repository.ProfileUpdate) error {
+	if update.Username != nil {
+		f.mu.Lock()
+		taken := f.taken(userID, "", *update.Username)
+		f.mu.Unlock()
+		if taken {
+			return repository.ErrDuplicate
+		}
+	}
+	return f.update(userID, func(u *models.User) bool {
+		if update.Username != nil {
+			u.Username = *update.Username
+		}
+		if update.DisplayName != nil {
+			u.DisplayName = *update.DisplayName
+		}
+		if update.Timezone != nil {
+			u.Timezone = *update.Timezone
+		}
+		return true
+	})
+}
+
+func (f *fakeUsers) SetPendingEmail(ctx context.Context, userID, email string) error {
+	return f.update(userID, func(u *models.User) bool {
+		u.PendingEmail = email
+		return true
+	})
+}
+
+func (f *fakeUsers) ChangeEmail(ctx context.Context, userID, email string) error {
+	f.mu.Lock()
+	taken := f.taken(userID, email, "")
+	f.mu.Unlock()
+	if taken {
+		return repository.ErrDuplicate
+	}
+	return f.update(userID, func(u *models.User) bool {
+		if u.PendingEmail != email {
+			return false
+		}
+		u.Email, u.EmailVerified, u.PendingEmail = email, true, ""
+		return true
+	})
+}
+
+func (f *fakeUsers) MarkDeleted(ctx context.Context, userID string) error {
+	return f.update(userID, func(u *models.User) bool {
+		if u.DeletedAt != nil {
+			return false
+		}
+		now := time.Now()
+		u.DeletedAt = &now
+		return true
+	})
+}
+
+func (f *fakeUsers) ListDeleted(ctx context.Context) ([]models.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	users := []models.User{}
+	for _, user := range f.users {
+		if user.DeletedAt != nil {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (f *fakeUsers) Purge(ctx context.Context, userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, user := range f.users {
+		if user.ID.Hex() == userID && user.DeletedAt != nil {
+			f.users = append(f.users[:i], f.users[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeUsers) Usernames(ctx context.Context, userIDs []string) (map[string]string, error) {
+	usernames := map[string]string{}
+	for _, userID := range userIDs {
+		if user, _ := f.GetByID(ctx, userID); user != nil {
+			usernames[userID] = user.Username
+		}
+	}
+	return usernames, nil
+}
+
+type fakeAPIKeys struct {
+	mu   sync.Mutex
+	keys []models.APIKey
+}
+
+func (f *fakeAPIKeys) Create(ctx context.Context, key *models.APIKey) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys = append(f.keys, *key)
+	return nil
+}
+
+func (f *fakeAPIKeys) GetByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, key := range f.keys {
+		if key.KeyHash == keyHash {
+			return &key, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (f *fakeAPIKeys) ListByUser(ctx context.Context, userID string) ([]models.APIKey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := []models.APIKey{}
+	for _, key := range f.keys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (f *fakeAPIKeys) Delete(ctx context.Context, userID, keyID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, key := range f.keys {
+		if key.ID == keyID && key.UserID == userID {
+			f.keys = append(f.keys[:i], f.keys[i+1:]...)
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+type fakeSessions struct {
+	mu       sync.Mutex
+	sessions []models.Session
+}
+
+func (f *fakeSessions) Create(ctx context.Context, session *models.Session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sessions = append(f.sessions, *session)
+	return nil
+}
+
+func (f *fakeSessions) Get(ctx context.Context, sessionID string) (*models.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, session := range f.sessions {
+		if session.ID == sessionID {
+			return &session, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (f *fakeSessions) ListActive(ctx context.Context, userID string) ([]models.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sessions := []models.Session{}
+	for _, session := range f.sessions {
+		if session.UserID == userID && session.Active() {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (f *fakeSessions) update(sessionID string, change func(*models.Session)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.sessions {
+		if f.sessions[i].ID == sessionID {
+			change(&f.sessions[i])
+		}
+	}
+}
+
+func (f *fakeSessions) Touch(ctx context.Context, sessionID, ip string, seenAt time.Time) error {
+	f.update(sessionID, func(session *models.Session) {
+		session.IP = ip
+		session.LastSeenAt = seenAt
+	})
+	return nil
+}
+
+func (f *fakeSessions) Extend(ctx context.Context, sessionID string, expiresAt time.Time) error {
+	f.update(sessionID, func(session *models.Session) {
+		if session.RevokedAt == nil {
+			session.ExpiresAt = expiresAt
+		}
+	})
+	return nil
+}
+
+func (f *fakeSessions) Revoke(ctx context.Context, sessionID string) error {
+	f.update(sessionID, func(session *models.Session) {
+		if session.RevokedAt == nil {
+			now := time.Now()
+			session.RevokedAt = &now
+		}
+	})
+	return nil
+}
+
+type fakeRefreshTokens struct {
+	mu     sync.Mutex
+	tokens []*models.RefreshToken
+}
+
+func (f *fakeRefreshTokens) Create(ctx context.Context, token *models.RefreshToken) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stored := *token
+	f.tokens = append(f.tokens, &stored)
+	return nil
+}
+
+func (f *fakeRefreshTokens) GetByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, token := range f.tokens {
+		if token.TokenHash == tokenHash {
+			result := *token
+			return &result, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (f *fakeRefreshTokens) MarkUsed(ctx context.Context, tokenID string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, token := range f.tokens {
+		if token.ID == tokenID && token.UsedAt == nil && token.RevokedAt == nil {
+			now := time.Now()
+			token.UsedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeRefreshTokens) RevokeFamily(ctx context.Context, familyID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, token := range f.tokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			now := time.Now()
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (f *fakeRefreshTokens) RevokeUser(ctx context.Context, userID, keepFamilyID string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	seen := map[string]bool{}
+	var families []string
+	for _, token := range f.tokens {
+		if token.UserID == userID && token.RevokedAt == nil && token.FamilyID != keepFamilyID {
+			now := time.Now()
+			token.RevokedAt = &now
+			if !seen[token.FamilyID] {
+				seen[token.FamilyID] = true
+				families = append(families, token.FamilyID)
+			}
+		}
+	}
+	return families, nil
+}
+
+// fakeMailer keeps sent messages instead of sending them.
+type fakeMailer struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (f *fakeMailer) Send(ctx context.Context, msg mail.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages = append(f.messages, msg)
+	return nil
+}
+
+// last returns the most recent message sent to "to".
+func (f *fakeMailer) last(to string) (mail.Message, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.messages) - 1; i >= 0; i-- {
+		if f.messages[i].To == to {
+			return f.messages[i], true
+		}
+	}
+	return mail.Message{}, false
+}
+
+// fakeFiles only knows about owners; files are never shared with other users.
+type fakeFiles struct {
+	mu       sync.Mutex
+	files    map[string]*models.File
+	grants   []models.FileGrant
+	searches int
+}
+
+// granted returns userID's permission on fileID through a grant, or "".
+// Callers hold f.mu.
+func (f *fakeFiles) granted(fileID, userID string) string {
+	for _, grant := range f.grants {
+		if grant.FileID == fileID && grant.UserID == userID {
+			return grant.Permission
+		}
+	}
+	return ""
+}
+
+func (f *fakeFiles) Create(ctx context.Context, file *models.File) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stored := *file
+	stored.Version = 1
+	f.files[file.FileID] = &stored
+	return nil
+}
+
+func (f *fakeFiles) Get(ctx context.Context, fileID, userID string) (*models.File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, ok := f.files[fileID]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	result := *file
+	if file.UserID == userID {
+		result.Permission = "owner"
+	} else {
+		result.Permission = f.granted(fileID, userID)
+	}
+	return &result, nil
+}
+
+func (f *fakeFiles) ListByUser(ctx context.Context, userID string, shared bool) ([]models.File, error) {
+	return f.Search(ctx, repository.SearchParams{UserID: userID, Shared: shared})
+}
+
+func (f *fakeFiles) Search(ctx context.Context, params repository.SearchParams) ([]models.File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.searches++
+
+	files := []models.File{}
+	for _, file := range f.files {
+		permission := "owner"
+		if params.Shared {
+			permission = f.granted(file.FileID, params.UserID)
+		} else if file.UserID != params.UserID {
+			permission = ""
+		}
+		if permission == "" || file.DeletedAt != nil {
+			continue
+		}
+		if !strings.Contains(strings.ToLower(file.Filename), strings.ToLower(params.Name)) {
+			continue
+		}
+		if !params.Date.IsZero() && file.UploadDate.Format(time.DateOnly) != params.Date.Format(time.DateOnly) {
+			continue
+		}
+		result := *file
+		result.Permission = permission
+		files = append(files, result)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].UploadDate.After(files[j].UploadDate) })
+
+	if params.Offset >= len(files) {
+		return []models.File{}, nil
+	}
+	files = files[params.Offset:]
+	if params.Limit > 0 && params.Limit < len(files) {
+		files = files[:params.Limit]
+	}
+	return files, nil
+}
+
+func (f *fakeFiles) ListTrashed(ctx context.Context, userID string) ([]models.File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	files := []models.File{}
+	for _, file := range f.files {
+		if file.UserID == userID && file.DeletedAt != nil {
+			files = append(files, *file)
+		}
+	}
+	return files, nil
+}
+
+func (f *fakeFiles) Update(ctx context.Context, fileID string, update repository.FileUpdate, expectedVersion *int) (*models.File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, ok := f.files[fileID]
+	if !ok || file.DeletedAt != nil {
+		return nil, repository.ErrNotFound
+	}
+	if expectedVersion != nil && *expectedVersion != file.Version {
+		return nil, repository.ErrVersionConflict
+	}
+	if update.Filename != nil {
+		file.Filename = *update.Filename
+	}
+	if update.Description != nil {
+		file.Description = *update.Description
+	}
+	if update.Tags != nil {
+		file.Tags = *update.Tags
+	}
+	file.Version++
+	result := *file
+	return &result, nil
+}
+
+func (f *fakeFiles) Trash(ctx context.Context, fileID string) (time.Time, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, ok := f.files[fileID]
+	if !ok || file.DeletedAt != nil {
+		return time.Time{}, repository.ErrNotFound
+	}
+	now := time.Now()
+	file.DeletedAt = &now
+	return now, nil
+}
+
+func (f *fakeFiles) Restore(ctx context.Context, fileID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, ok := f.files[fileID]
+	if !ok || file.DeletedAt == nil {
+		return repository.ErrNotFound
+	}
+	file.DeletedAt = nil
+	return nil
+}
+
+func (f *fakeFiles) ListOwned(ctx context.Context, userID string, limit int) ([]models.File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	files := []models.File{}
+	for _, file := range f.files {
+		if file.UserID == userID && len(files) < limit {
+			files = append(files, *file)
+		}
+	}
+	return files, nil
+}
+
+func (f *fakeFiles) ListExpired(ctx context.Context, uploadedBefore, trashedBefore time.Time) ([]models.File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	files := []models.File{}
+	for _, file := range f.files {
+		if (file.DeletedAt == nil && file.UploadDate.Before(uploadedBefore)) || (file.DeletedAt != nil && file.DeletedAt.Before(trashedBefore)) {
+			files = append(files, *file)
+		}
+	}
+	return files, nil
+}
+
+func (f *fakeFiles) Usage(ctx context.Context, userID string) (*repository.Usage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var usage repository.Usage
+	for _, file := range f.files {
+		if file.UserID != userID {
+			continue
+		}
+		if file.DeletedAt == nil {
+			usage.Files++
+		} else {
+			usage.TrashedFiles++
+		}
+		usage.Bytes += file.FileSize
+	}
+	return &usage, nil
+}
+
+func (f *fakeFiles) Delete(ctx context.Context, fileID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.files, fileID)
+	return nil
+}
+
+// fakePermissions keeps grants on the files in fakeFiles, which honours them.
+type fakePermissions struct {
+	files *fakeFiles
+}
+
+func (f *fakePermissions) Grant(ctx context.Context, grant *models.FileGrant) error {
+	f.files.mu.Lock()
+	defer f.files.mu.Unlock()
+	for i := range f.files.grants {
+		if f.files.grants[i].FileID == grant.FileID && f.files.grants[i].UserID == grant.UserID {
+			f.files.grants[i].Permission, f.files.grants[i].GrantedBy = grant.Permission, grant.GrantedBy
+			return nil
+		}
+	}
+	stored := *grant
+	stored.CreatedAt = time.Now()
+	f.files.grants = append(f.files.grants, stored)
+	return nil
+}
+
+func (f *fakePermissions) List(ctx context.Context, fileID string) ([]models.FileGrant, error) {
+	f.files.mu.Lock()
+	defer f.files.mu.Unlock()
+	grants := []models.FileGrant{}
+	for _, grant := range f.files.grants {
+		if grant.FileID == fileID {
+			grants = append(grants, grant)
+		}
+	}
+	return grants, nil
+}
+
+func (f *fakePermissions) Revoke(ctx context.Context, fileID, userID string) error {
+	f.files.mu.Lock()
+	defer f.files.mu.Unlock()
+	for i, grant := range f.files.grants {
+		if grant.FileID == fileID && grant.UserID == userID {
+			f.files.grants = append(f.files.grants[:i], f.files.grants[i+1:]...)
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+// fakeShares keeps share links and tokens for the files in fakeFiles.
+type fakeShares struct {
+	mu     sync.Mutex
+	files  *fakeFiles
+	links  []models.ShareLink
+	shares []*models.Share
+}
+
+func (f *fakeShares) CreateLink(ctx context.Context, link *models.ShareLink) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.links = append(f.links, *link)
+	return nil
+}
+
+func (f *fakeShares) ListActiveLinks(ctx context.Context, fileID string) ([]models.ShareLink, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	links := []models.ShareLink{}
+	for _, link := range f.links {
+		if link.FileID == fileID && link.ExpiresAt.After(time.Now()) {
+			links = append(links, link)
+		}
+	}
+	return links, nil
+}
+
+func (f *fakeShares) CreateShare(ctx context.Context, share *models.Share) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stored := *share
+	f.shares = append(f.shares, &stored)
+	return nil
+}
+
+func (f *fakeShares) ListShares(ctx context.Context, fileID string) ([]models.Share, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	shares := []models.Share{}
+	for _, share := range f.shares {
+		if share.FileID == fileID {
+			shares = append(shares, *share)
+		}
+	}
+	return shares, nil
+}
+
+func (f *fakeShares) GetShareByTokenHash(ctx context.Context, tokenHash string) (*models.Share, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, share := range f.shares {
+		if share.TokenHash != tokenHash {
+			continue
+		}
+		f.files.mu.Lock()
+		file, ok := f.files.files[share.FileID]
+		f.files.mu.Unlock()
+		if !ok || file.DeletedAt != nil {
+			return nil, repository.ErrNotFound
+		}
+		found := *share
+		found.Filename = file.Filename
+		return &found, nil
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (f *fakeShares) RevokeShare(ctx context.Context, fileID, shareID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, share := range f.shares {
+		if share.ShareID == shareID && share.FileID == fileID && share.RevokedAt == nil {
+			now := time.Now()
+			share.RevokedAt = &now
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+func (f *fakeShares) RecordShareAccess(ctx context.Context, shareID string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, share := range f.shares {
+		if share.ShareID == shareID && share.RevokedAt == nil &&
+			(share.MaxDownloads == nil || share.DownloadCount < *share.MaxDownloads) {
+			share.DownloadCount++
+			return true, nil
+		}
+	}
+	return false, nil
+}
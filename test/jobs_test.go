@@ -0,0 +1,68 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"trademarkia/jobs"
+	"trademarkia/models"
+	"trademarkia/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stuckStorage cannot delete the object with the key stuck.
+type stuckStorage struct {
+	storage.Storage
+	stuck string
+}
+
+func (s stuckStorage) Delete(ctx context.Context, key string) error {
+	if key == s.stuck {
+		return errors.New("storage unavailable")
+	}
+	return s.Storage.Delete(ctx, key)
+}
+
+func TestFileDeletionJob(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	days := func(n int) time.Time { return now.Add(-time.Duration(n) * 24 * time.Hour) }
+
+	files := &fakeFiles{files: map[string]*models.File{}}
+	objects := storage.NewMemoryStorage(storage.NewURLSigner("http://localhost:8000", "test-secret"))
+	add := func(fileID string, uploaded time.Time, trashed *time.Time) {
+		files.files[fileID] = &models.File{FileID: fileID, UserID: "alice", UploadDate: uploaded, DeletedAt: trashed}
+		require.NoError(t, objects.Put(ctx, fileID, strings.NewReader(fileID), storage.PutOptions{}))
+	}
+	trashedLongAgo := days(31)
+	trashedToday := now
+
+	add("recent", days(1), nil)
+	add("old", days(4), nil)
+	add("stuck", days(4), nil)
+	add("trashed-recent", days(1), &trashedToday)
+	add("trashed-long-ago", days(40), &trashedLongAgo)
+	// Trashed files wait out their retention however old the upload is
+	add("old-trashed-recent", days(10), &trashedToday)
+
+	deleter := &jobs.FileDeleter{Files: files, Storage: stuckStorage{Storage: objects, stuck: "stuck"}}
+	deleter.Run(ctx, now)
+
+	remaining := map[string]bool{}
+	for fileID := range files.files {
+		remaining[fileID] = true
+		_, err := objects.Stat(ctx, fileID)
+		assert.NoError(t, err, "row %s lost its object", fileID)
+	}
+	// A row whose object could not be deleted is kept for the next run
+	assert.Equal(t, map[string]bool{"recent": true, "stuck": true, "trashed-recent": true, "old-trashed-recent": true}, remaining)
+
+	for _, fileID := range []string{"old", "trashed-long-ago"} {
+		_, err := objects.Stat(ctx, fileID)
+		assert.ErrorIs(t, err, storage.ErrNotFound, fileID)
+	}
+}
@@ -0,0 +1,158 @@
+package test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"trademarkia/cache"
+	"trademarkia/config"
+	"trademarkia/models"
+	"trademarkia/tokens"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+}
+
+func tokenHeader(t *testing.T, tokenString string) map[string]interface{} {
+	t.Helper()
+	token, _, err := jwt.NewParser().ParseUnverified(tokenString, jwt.MapClaims{})
+	require.NoError(t, err)
+	return token.Header
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	writePEM(t, filepath.Join(dir, "2024-01-01.pem"), "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+
+	keys, err := tokens.LoadKeySet(dir)
+	require.NoError(t, err)
+	manager := tokens.NewManager(cache.NewMemoryCache(), keys)
+
+	oldToken, _, err := manager.IssueAccessToken("user-1", "family-1", models.RoleUser)
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01-01", tokenHeader(t, oldToken)["kid"])
+	assert.Equal(t, "RS256", tokenHeader(t, oldToken)["alg"])
+
+	// A newly added key is published straight away but only signs once
+	// consumers have had time to refetch the key set
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	newKeyPath := filepath.Join(dir, "2024-06-01.pem")
+	writePEM(t, newKeyPath, "PRIVATE KEY", der)
+	require.NoError(t, keys.Reload())
+	assert.Len(t, keys.JWKS(), 2)
+
+	pendingToken, _, err := manager.IssueAccessToken("user-1", "family-1", models.RoleUser)
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01-01", tokenHeader(t, pendingToken)["kid"])
+
+	// Once it has been published for long enough it takes over signing; tokens
+	// signed with the old key still verify
+	published := time.Now().Add(-tokens.JWKSMaxAge - time.Second)
+	require.NoError(t, os.Chtimes(newKeyPath, published, published))
+	require.NoError(t, keys.Reload())
+
+	newToken, _, err := manager.IssueAccessToken("user-1", "family-1", models.RoleUser)
+	require.NoError(t, err)
+	assert.Equal(t, "2024-06-01", tokenHeader(t, newToken)["kid"])
+	assert.Equal(t, "EdDSA", tokenHeader(t, newToken)["alg"])
+
+	claims, err := manager.ParseAccessToken(ctx, oldToken)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", claims.UserID)
+	assert.Equal(t, "family-1", claims.FamilyID)
+	_, err = manager.ParseAccessToken(ctx, newToken)
+	require.NoError(t, err)
+
+	jwks := keys.JWKS()
+	require.Len(t, jwks, 2)
+	assert.Equal(t, "RSA", jwks[0].KeyType)
+	assert.NotEmpty(t, jwks[0].N)
+	assert.Equal(t, "OKP", jwks[1].KeyType)
+	assert.Equal(t, "Ed25519", jwks[1].Curve)
+
+	// Keeping only the public half of a retired key still verifies its tokens
+	der, err = x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	writePEM(t, filepath.Join(dir, "2024-01-01.pem"), "PUBLIC KEY", der)
+	require.NoError(t, keys.Reload())
+	_, err = manager.ParseAccessToken(ctx, oldToken)
+	require.NoError(t, err)
+
+	// Removing it entirely does not
+	require.NoError(t, os.Remove(filepath.Join(dir, "2024-01-01.pem")))
+	require.NoError(t, keys.Reload())
+	_, err = manager.ParseAccessToken(ctx, oldToken)
+	assert.ErrorIs(t, err, tokens.ErrInvalidToken)
+
+	// Tokens must be issued to us, by us, with the algorithm of their key
+	sign := func(method jwt.SigningMethod, key interface{}, claims jwt.RegisteredClaims) string {
+		claims.Subject = "user-1"
+		claims.ID = "token-1"
+		claims.IssuedAt = jwt.NewNumericDate(time.Now())
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Minute))
+		token := jwt.NewWithClaims(method, struct {
+			jwt.RegisteredClaims
+			SessionID string `json:"sid"`
+		}{claims, "family-1"})
+		token.Header["kid"] = "2024-06-01"
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		return signed
+	}
+	valid := jwt.RegisteredClaims{Issuer: config.JWT_ISSUER, Audience: jwt.ClaimStrings{config.JWT_AUDIENCE}}
+	_, err = manager.ParseAccessToken(ctx, sign(jwt.SigningMethodEdDSA, edKey, valid))
+	require.NoError(t, err)
+
+	wrongAudience := valid
+	wrongAudience.Audience = jwt.ClaimStrings{"another-service"}
+	_, err = manager.ParseAccessToken(ctx, sign(jwt.SigningMethodEdDSA, edKey, wrongAudience))
+	assert.ErrorIs(t, err, tokens.ErrInvalidToken)
+
+	wrongIssuer := valid
+	wrongIssuer.Issuer = "https://evil.example.com"
+	_, err = manager.ParseAccessToken(ctx, sign(jwt.SigningMethodEdDSA, edKey, wrongIssuer))
+	assert.ErrorIs(t, err, tokens.ErrInvalidToken)
+
+	_, err = manager.ParseAccessToken(ctx, sign(jwt.SigningMethodHS256, []byte(edKey.Public().(ed25519.PublicKey)), valid))
+	assert.ErrorIs(t, err, tokens.ErrInvalidToken)
+}
+
+func TestJWKSEndpoint(t *testing.T) {
+	app, _, _ := newTestApp()
+
+	resp, err := app.Fiber.Test(httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Keys []tokens.JWK `json:"keys"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Len(t, body.Keys, 1)
+	assert.Equal(t, "EdDSA", body.Keys[0].Algorithm)
+	assert.Equal(t, "sig", body.Keys[0].Use)
+	assert.NotEmpty(t, body.Keys[0].KeyID)
+}
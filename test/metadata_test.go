@@ -0,0 +1,121 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"trademarkia/models"
+	"trademarkia/repository"
+	"trademarkia/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateFileMetadata(t *testing.T) {
+	app, _, _ := newTestApp()
+	client := testClient{t: t, app: app}
+	alice := client.signup("alice")
+	fileID := client.upload(alice, "draft.txt", "first draft")
+	path := "/files/" + fileID
+
+	update := func(body string, headers ...string) *http.Response {
+		return client.do(http.MethodPatch, path, alice, body, headers...)
+	}
+	errorCode := func(resp *http.Response) string {
+		var body struct {
+			Error struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return body.Error.Code
+	}
+
+	t.Run("updates and versions", func(t *testing.T) {
+		resp := update(`{"filename":"  final.txt ","description":"ready","tags":[" work ","urgent","work",""]}`)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, `"2"`, resp.Header.Get("ETag"))
+		var updated models.File
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&updated))
+		assert.Equal(t, "final.txt", updated.Filename)
+		assert.Equal(t, "ready", updated.Description)
+		assert.Equal(t, []string{"work", "urgent"}, updated.Tags)
+		assert.Equal(t, 2, updated.Version)
+		assert.Equal(t, "owner", updated.Permission)
+
+		// Fields left out are kept
+		resp = update(`{"description":""}`)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&updated))
+		assert.Equal(t, "final.txt", updated.Filename)
+		assert.Equal(t, []string{"work", "urgent"}, updated.Tags)
+		assert.Equal(t, 3, updated.Version)
+	})
+
+	t.Run("stale versions are rejected", func(t *testing.T) {
+		resp := update(`{"description":"mine"}`, "If-Match", `"1"`)
+		assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+		assert.Equal(t, "precondition_failed", errorCode(resp))
+		resp = update(`{"description":"mine","version":2}`)
+		assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+		assert.Equal(t, http.StatusPreconditionFailed, update(`{"description":"mine"}`, "If-Match", "not-a-version").StatusCode)
+
+		// If-Match wins over the body, and weak validators are accepted
+		resp = update(`{"description":"mine","version":1}`, "If-Match", `W/"3"`)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, `"4"`, resp.Header.Get("ETag"))
+		assert.Equal(t, http.StatusOK, update(`{"description":"again","version":4}`).StatusCode)
+	})
+
+	t.Run("validation errors", func(t *testing.T) {
+		for _, body := range []string{
+			`{}`,
+			`{"version":5}`,
+			`{"filename":""}`,
+			`{"filename":"a/b.txt"}`,
+			`{"filename":"` + strings.Repeat("a", 256) + `"}`,
+			`{"description":"` + strings.Repeat("a", 2001) + `"}`,
+			`{"tags":["` + strings.Repeat("a", 51) + `"]}`,
+			`not json`,
+		} {
+			resp := update(body)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+			assert.Equal(t, "validation_error", errorCode(resp), body)
+		}
+
+		// Nothing was changed by the rejected requests
+		var files []models.File
+		client.read(client.do(http.MethodGet, "/files", alice, ""), &files)
+		require.Len(t, files, 1)
+		assert.Equal(t, "final.txt", files[0].Filename)
+		assert.Equal(t, 5, files[0].Version)
+	})
+}
+
+// trashingFiles trashes a file just before updating it, like a request racing
+// one that moves the file to the trash.
+type trashingFiles struct {
+	*fakeFiles
+}
+
+func (f trashingFiles) Update(ctx context.Context, fileID string, update repository.FileUpdate, expectedVersion *int) (*models.File, error) {
+	if _, err := f.Trash(ctx, fileID); err != nil {
+		return nil, err
+	}
+	return f.fakeFiles.Update(ctx, fileID, update, expectedVersion)
+}
+
+func TestUpdateTrashedFile(t *testing.T) {
+	app, _, _ := newTestAppWith(func(deps *server.Dependencies) {
+		deps.Files = trashingFiles{deps.Files.(*fakeFiles)}
+	})
+	client := testClient{t: t, app: app}
+	alice := client.signup("alice")
+	fileID := client.upload(alice, "draft.txt", "first draft")
+
+	// A file trashed under the update is missing, not a version conflict
+	resp := client.do(http.MethodPatch, "/files/"+fileID, alice, `{"description":"mine","version":1}`)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,134 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPTwoFactor(t *testing.T) {
+	app, _, _ := newTestApp()
+
+	do := func(method, path, token, body string) *http.Response {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := app.Fiber.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+	read := func(resp *http.Response, v interface{}) {
+		t.Helper()
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+	login := func() string {
+		var challenge struct {
+			MFARequired bool   `json:"mfa_required"`
+			MFAToken    string `json:"mfa_token"`
+		}
+		resp := do(http.MethodPost, "/login", "", `{"email":"alice@example.com","password":"secret-123"}`)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		read(resp, &challenge)
+		require.True(t, challenge.MFARequired)
+		require.NotEmpty(t, challenge.MFAToken)
+		return challenge.MFAToken
+	}
+	loginMFA := func(mfaToken, code string) *http.Response {
+		return do(http.MethodPost, "/login/mfa", "", `{"mfa_token":"`+mfaToken+`","code":"`+code+`"}`)
+	}
+
+	resp := do(http.MethodPost, "/register", "", `{"email":"alice@example.com","username":"alice","password":"secret-123"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	session := decode(t, do(http.MethodPost, "/login", "", `{"email":"alice@example.com","password":"secret-123"}`))
+
+	var enrollment struct {
+		Secret     string `json:"secret"`
+		OTPAuthURL string `json:"otpauth_url"`
+	}
+	resp = do(http.MethodPost, "/mfa/totp/enroll", session.Token, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	read(resp, &enrollment)
+	require.NotEmpty(t, enrollment.Secret)
+	assert.True(t, strings.HasPrefix(enrollment.OTPAuthURL, "otpauth://totp/"))
+
+	// Codes are single use, so each step takes one from a different period
+	now := time.Now()
+	code := func(offset time.Duration) string {
+		code, err := totp.GenerateCode(enrollment.Secret, now.Add(offset))
+		require.NoError(t, err)
+		return code
+	}
+
+	resp = do(http.MethodPost, "/mfa/totp/confirm", session.Token, `{"code":"000000x"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	var confirmation struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	resp = do(http.MethodPost, "/mfa/totp/confirm", session.Token, `{"code":"`+code(0)+`"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	read(resp, &confirmation)
+	require.Len(t, confirmation.RecoveryCodes, 10)
+
+	resp = do(http.MethodPost, "/mfa/totp/enroll", session.Token, "")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	t.Run("login with a code", func(t *testing.T) {
+		mfaToken := login()
+
+		// The challenge token is not an access token
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/files", mfaToken, "").StatusCode)
+
+		// A code that was already used to confirm is rejected
+		assert.Equal(t, http.StatusUnauthorized, loginMFA(mfaToken, code(0)).StatusCode)
+
+		decode(t, loginMFA(mfaToken, code(-30*time.Second)))
+
+		// The challenge token only works once
+		assert.Equal(t, http.StatusUnauthorized, loginMFA(mfaToken, code(30*time.Second)).StatusCode)
+	})
+
+	t.Run("login with a recovery code", func(t *testing.T) {
+		recovery := strings.ToLower(confirmation.RecoveryCodes[0])
+		decode(t, loginMFA(login(), recovery))
+		assert.Equal(t, http.StatusUnauthorized, loginMFA(login(), recovery).StatusCode)
+	})
+
+	t.Run("attempts are limited", func(t *testing.T) {
+		// Wrong codes count towards the same lockout as wrong passwords, so
+		// logging in again for a fresh challenge does not allow more guesses
+		status := 0
+		for i := 0; i < 5 && status != http.StatusTooManyRequests; i++ {
+			status = loginMFA(login(), "AAAAA-AAAAA").StatusCode
+		}
+		assert.Equal(t, http.StatusTooManyRequests, status)
+
+		resp := do(http.MethodPost, "/login", "", `{"email":"alice@example.com","password":"secret-123"}`)
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		resp := do(http.MethodPost, "/mfa/totp/disable", session.Token, `{"code":"wrong"}`)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		resp = do(http.MethodPost, "/mfa/totp/disable", session.Token, `{"code":"`+confirmation.RecoveryCodes[2]+`"}`)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var me struct {
+			MFAEnabled bool `json:"mfa_enabled"`
+		}
+		resp = do(http.MethodGet, "/me", session.Token, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		read(resp, &me)
+		assert.False(t, me.MFAEnabled)
+	})
+}
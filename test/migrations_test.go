@@ -0,0 +1,27 @@
+package test
+
+import (
+	"testing"
+	"trademarkia/migrations"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrationsLoad(t *testing.T) {
+	loaded, err := migrations.Load()
+	require.NoError(t, err)
+	require.NotEmpty(t, loaded)
+
+	// Versions must be unique and increasing, and every migration reversible
+	for i, m := range loaded {
+		assert.NotEmpty(t, m.Up, "migration %d has no up script", m.Version)
+		assert.NotEmpty(t, m.Down, "migration %d has no down script", m.Version)
+		if i > 0 {
+			assert.Greater(t, m.Version, loaded[i-1].Version)
+		}
+	}
+	assert.Equal(t, "create_files", loaded[0].Name)
+	assert.Contains(t, loaded[0].Up, "files_user_id_idx")
+	assert.Contains(t, loaded[0].Up, "files_upload_date_idx")
+}
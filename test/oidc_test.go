@@ -0,0 +1,268 @@
+package test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"trademarkia/identity"
+	"trademarkia/server"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+}
+
+type mockGrant struct {
+	nonce     string
+	challenge string
+	user      mockIdentity
+}
+
+// mockOIDCProvider is a minimal OpenID Connect provider. Whoever visits
+// /authorize is signed in as user straight away.
+type mockOIDCProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	user   mockIdentity
+	grants map[string]mockGrant
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p := &mockOIDCProvider{key: key, grants: map[string]mockGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "mock",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("client_id") != "client" || query.Get("code_challenge_method") != "S256" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		code := uuid.New().String()
+		p.mu.Lock()
+		p.grants[code] = mockGrant{nonce: query.Get("nonce"), challenge: query.Get("code_challenge"), user: p.user}
+		p.mu.Unlock()
+
+		redirect, _ := url.Parse(query.Get("redirect_uri"))
+		redirect.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		p.mu.Lock()
+		grant, ok := p.grants[r.Form.Get("code")]
+		delete(p.grants, r.Form.Get("code"))
+		p.mu.Unlock()
+
+		verifier := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		now := time.Now()
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":                p.URL,
+			"sub":                grant.user.Subject,
+			"aud":                "client",
+			"iat":                now.Unix(),
+			"exp":                now.Add(time.Minute).Unix(),
+			"nonce":              grant.nonce,
+			"email":              grant.user.Email,
+			"email_verified":     grant.user.EmailVerified,
+			"preferred_username": grant.user.Username,
+		})
+		token.Header["kid"] = "mock"
+		idToken, _ := token.SignedString(key)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "mock-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   60,
+			"id_token":     idToken,
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestOIDCLogin(t *testing.T) {
+	provider := newMockOIDCProvider(t)
+	app, users, _ := newTestAppWith(func(deps *server.Dependencies) {
+		var err error
+		deps.Identity, err = identity.NewProvider(context.Background(), provider.URL,
+			"client", "secret", "http://localhost:8000/auth/oidc/callback", []string{"email", "profile"})
+		require.NoError(t, err)
+	})
+	noRedirects := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	// startSignIn goes through the provider and returns the callback request
+	// the browser would make
+	startSignIn := func(user mockIdentity) *http.Request {
+		provider.user = user
+		resp, err := app.Fiber.Test(httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusFound, resp.StatusCode)
+		cookies := resp.Cookies()
+		require.Len(t, cookies, 1)
+
+		resp, err = noRedirects.Get(resp.Header.Get("Location"))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusFound, resp.StatusCode)
+		callback, err := url.Parse(resp.Header.Get("Location"))
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+		req.AddCookie(cookies[0])
+		return req
+	}
+	signIn := func(user mockIdentity) *http.Response {
+		resp, err := app.Fiber.Test(startSignIn(user))
+		require.NoError(t, err)
+		return resp
+	}
+	password := func(email, password string) int {
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"email":"`+email+`","password":"`+password+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Fiber.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+	register := func(name string) {
+		req := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(`{"email":"`+name+`@corp.example","username":"`+name+`","password":"secret-123"}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Fiber.Test(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	t.Run("new user", func(t *testing.T) {
+		carol := mockIdentity{Subject: "carol-1", Email: "Carol@corp.example", EmailVerified: true, Username: "carol"}
+		session := decode(t, signIn(carol))
+
+		req := httptest.NewRequest(http.MethodGet, "/files", nil)
+		req.Header.Set("Authorization", "Bearer "+session.Token)
+		resp, err := app.Fiber.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		user, err := users.GetByEmail(context.Background(), "carol@corp.example")
+		require.NoError(t, err)
+		assert.Equal(t, "carol", user.Username)
+		assert.True(t, user.EmailVerified)
+
+		// Signing in again finds the same account
+		decode(t, signIn(carol))
+		assert.Len(t, users.users, 1)
+	})
+
+	t.Run("usernames fit the sign up rules", func(t *testing.T) {
+		long := strings.Repeat("a", 40)
+		for i, test := range []struct{ name, want string }{
+			{"jo", "user-jo"},
+			{"j o", "user-jo2"},
+			{"!!", "user"},
+			{long, long[:32]},
+			{long, long[:31] + "2"},
+		} {
+			email := fmt.Sprintf("short-%d@corp.example", i)
+			decode(t, signIn(mockIdentity{Subject: email, Email: email, EmailVerified: true, Username: test.name}))
+			user, err := users.GetByEmail(context.Background(), email)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, user.Username, test.name)
+		}
+	})
+
+	t.Run("linking by verified email", func(t *testing.T) {
+		register("dave")
+		dave, err := users.GetByEmail(context.Background(), "dave@corp.example")
+		require.NoError(t, err)
+		require.NoError(t, users.MarkEmailVerified(context.Background(), dave.ID.Hex(), dave.Email))
+
+		// Providers do not always keep the case an address was registered in
+		decode(t, signIn(mockIdentity{Subject: "dave-1", Email: "Dave@Corp.example", EmailVerified: true}))
+		dave, err = users.GetByEmail(context.Background(), "dave@corp.example")
+		require.NoError(t, err)
+		assert.Len(t, dave.Identities, 1)
+		assert.Equal(t, http.StatusOK, password("dave@corp.example", "secret-123"))
+
+		// A password set by whoever registered an address they never
+		// verified stops working once its owner signs in
+		register("erin")
+		decode(t, signIn(mockIdentity{Subject: "erin-1", Email: "erin@corp.example", EmailVerified: true}))
+		assert.Equal(t, http.StatusUnauthorized, password("erin@corp.example", "secret-123"))
+	})
+
+	t.Run("rejected sign ins", func(t *testing.T) {
+		resp := signIn(mockIdentity{Subject: "frank-1", Email: "frank@corp.example"})
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		// The callback only works once, and only in the browser that started it
+		req := startSignIn(mockIdentity{Subject: "carol-1", Email: "carol@corp.example", EmailVerified: true})
+		stolen := httptest.NewRequest(http.MethodGet, req.URL.RequestURI(), nil)
+		resp, err := app.Fiber.Test(stolen)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		resp, err = app.Fiber.Test(req)
+		require.NoError(t, err)
+		decode(t, resp)
+		replay := httptest.NewRequest(http.MethodGet, req.URL.RequestURI(), nil)
+		replay.AddCookie(req.Cookies()[0])
+		resp, err = app.Fiber.Test(replay)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		resp, err = app.Fiber.Test(httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?error=access_denied", nil))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}
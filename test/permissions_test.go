@@ -0,0 +1,120 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+	"trademarkia/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilePermissions(t *testing.T) {
+	app, _, _ := newTestApp()
+	client := testClient{t: t, app: app}
+	owner := client.signup("alice")
+	editor := client.signup("bob")
+	reader := client.signup("carol")
+	stranger := client.signup("dave")
+	fileID := client.upload(owner, "plan.txt", "the plan")
+
+	grant := func(user, permission string) *http.Response {
+		return client.do(http.MethodPost, "/files/"+fileID+"/permissions", owner, `{"user":"`+user+`","permission":"`+permission+`"}`)
+	}
+	require.Equal(t, http.StatusOK, grant("bob", "read").StatusCode)
+	// Granting again replaces the permission
+	require.Equal(t, http.StatusOK, grant("bob@example.com", "edit").StatusCode)
+	require.Equal(t, http.StatusOK, grant("carol", "read").StatusCode)
+
+	t.Run("matrix", func(t *testing.T) {
+		type action struct {
+			name, method, path, body string
+			// allowed is the status for callers with enough access
+			allowed int
+			// least is the lowest permission allowed
+			least string
+		}
+		actions := []action{
+			{"download", http.MethodGet, "/files/" + fileID + "/content", "", http.StatusOK, "read"},
+			{"rename", http.MethodPatch, "/files/" + fileID, `{"description":"updated"}`, http.StatusOK, "edit"},
+			{"share link", http.MethodPost, "/share/" + fileID, "", http.StatusCreated, "owner"},
+			{"list share links", http.MethodGet, "/share/" + fileID + "/links", "", http.StatusOK, "owner"},
+			{"create share", http.MethodPost, "/files/" + fileID + "/shares", `{}`, http.StatusCreated, "owner"},
+			{"list shares", http.MethodGet, "/files/" + fileID + "/shares", "", http.StatusOK, "owner"},
+			{"list permissions", http.MethodGet, "/files/" + fileID + "/permissions", "", http.StatusOK, "owner"},
+			{"grant", http.MethodPost, "/files/" + fileID + "/permissions", `{"user":"dave","permission":"read"}`, http.StatusOK, "owner"},
+			{"revoke", http.MethodDelete, "/files/" + fileID + "/permissions/nobody", "", http.StatusNotFound, "owner"},
+		}
+		levels := map[string]int{"read": 1, "edit": 2, "owner": 3}
+		// Least access first, so the owner's grant comes after dave's turn
+		callers := []struct {
+			permission, token string
+		}{
+			{"none", stranger},
+			{"read", reader},
+			{"edit", editor},
+			{"owner", owner},
+		}
+
+		for _, action := range actions {
+			for _, caller := range callers {
+				want := action.allowed
+				switch {
+				case caller.permission == "none":
+					// Files a caller cannot see are reported missing
+					want = http.StatusNotFound
+				case levels[caller.permission] < levels[action.least]:
+					want = http.StatusForbidden
+				}
+				resp := client.do(action.method, action.path, caller.token, action.body)
+				assert.Equal(t, want, resp.StatusCode, "%s by %s", action.name, caller.permission)
+			}
+			// The grant above gave dave access; take it back for the next action
+			if action.name == "grant" {
+				require.Equal(t, http.StatusOK, client.do(http.MethodDelete, "/files/"+fileID+"/permissions/"+userID(t, client, stranger), owner, "").StatusCode)
+			}
+		}
+	})
+
+	t.Run("shared view", func(t *testing.T) {
+		for token, permission := range map[string]string{editor: "edit", reader: "read"} {
+			var shared []models.File
+			client.read(client.do(http.MethodGet, "/files?view=shared", token, ""), &shared)
+			require.Len(t, shared, 1)
+			assert.Equal(t, fileID, shared[0].FileID)
+			assert.Equal(t, permission, shared[0].Permission)
+
+			var own []models.File
+			client.read(client.do(http.MethodGet, "/files", token, ""), &own)
+			assert.Empty(t, own)
+		}
+	})
+
+	t.Run("invalid grants", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, grant("alice", "read").StatusCode)
+		assert.Equal(t, http.StatusNotFound, grant("nobody", "read").StatusCode)
+		assert.Equal(t, http.StatusBadRequest, grant("dave", "owner").StatusCode)
+	})
+
+	t.Run("revoke and trash", func(t *testing.T) {
+		carolID := userID(t, client, reader)
+		require.Equal(t, http.StatusOK, client.do(http.MethodDelete, "/files/"+fileID+"/permissions/"+carolID, owner, "").StatusCode)
+		assert.Equal(t, http.StatusNotFound, client.do(http.MethodGet, "/files/"+fileID+"/content", reader, "").StatusCode)
+
+		// Only the owner can trash and restore a file, and grants do not
+		// reach into the trash
+		assert.Equal(t, http.StatusForbidden, client.do(http.MethodDelete, "/files/"+fileID, editor, "").StatusCode)
+		require.Equal(t, http.StatusOK, client.do(http.MethodDelete, "/files/"+fileID, owner, "").StatusCode)
+		assert.Equal(t, http.StatusNotFound, client.do(http.MethodGet, "/files/"+fileID+"/content", editor, "").StatusCode)
+		assert.Equal(t, http.StatusForbidden, client.do(http.MethodPost, "/trash/"+fileID+"/restore", editor, "").StatusCode)
+		assert.Equal(t, http.StatusOK, client.do(http.MethodPost, "/trash/"+fileID+"/restore", owner, "").StatusCode)
+	})
+}
+
+// userID returns the ID of the user token belongs to.
+func userID(t *testing.T, client testClient, token string) string {
+	t.Helper()
+	var me models.User
+	client.read(client.do(http.MethodGet, "/me", token, ""), &me)
+	return me.ID.Hex()
+}
@@ -0,0 +1,234 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"trademarkia/jobs"
+	"trademarkia/models"
+	"trademarkia/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountManagement(t *testing.T) {
+	app, users, files := newTestApp()
+	mailer := app.Auth.Mailer.(*fakeMailer)
+
+	do := func(method, path, token, body string) *http.Response {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := app.Fiber.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+	login := func(email, password string) *http.Response {
+		return do(http.MethodPost, "/login", "", `{"email":"`+email+`","password":"`+password+`"}`)
+	}
+	me := func(token string) models.User {
+		resp := do(http.MethodGet, "/me", token, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var user models.User
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&user))
+		return user
+	}
+
+	for _, user := range []string{"alice", "bob"} {
+		resp := do(http.MethodPost, "/register", "", `{"email":"`+user+`@example.com","username":"`+user+`","password":"secret-123"}`)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	session := decode(t, login("alice@example.com", "secret-123"))
+
+	t.Run("profile", func(t *testing.T) {
+		assert.Equal(t, "alice", me(session.Token).Username)
+
+		resp := do(http.MethodPatch, "/me", session.Token, `{"username":"bob"}`)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		resp = do(http.MethodPatch, "/me", session.Token, `{"timezone":"Mars/Olympus_Mons"}`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp = do(http.MethodPatch, "/me", session.Token, `{"username":"al ice"}`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp = do(http.MethodPatch, "/me", session.Token, `{}`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = do(http.MethodPatch, "/me", session.Token, `{"username":"alicia","display_name":"Alicia","timezone":"Europe/Berlin"}`)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		user := me(session.Token)
+		assert.Equal(t, "alicia", user.Username)
+		assert.Equal(t, "Alicia", user.DisplayName)
+		assert.Equal(t, "Europe/Berlin", user.Timezone)
+	})
+
+	t.Run("change password", func(t *testing.T) {
+		other := decode(t, login("alice@example.com", "secret-123"))
+
+		resp := do(http.MethodPost, "/me/password", session.Token, `{"current_password":"wrong","new_password":"new-secret"}`)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = do(http.MethodPost, "/me/password", session.Token, `{"current_password":"secret-123","new_password":"new-secret"}`)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// Only the session that changed the password survives
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/files", session.Token, "").StatusCode)
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/files", other.Token, "").StatusCode)
+		assert.Equal(t, http.StatusUnauthorized, login("alice@example.com", "secret-123").StatusCode)
+		assert.Equal(t, http.StatusOK, login("alice@example.com", "new-secret").StatusCode)
+	})
+
+	t.Run("change email", func(t *testing.T) {
+		resp := do(http.MethodPost, "/me/email", session.Token, `{"email":"bob@example.com","password":"new-secret"}`)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		resp = do(http.MethodPost, "/me/email", session.Token, `{"email":"alice@example.org","password":"wrong"}`)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = do(http.MethodPost, "/me/email", session.Token, `{"email":"alice@example.org","password":"new-secret"}`)
+		require.Equal(t, http.StatusAccepted, resp.StatusCode)
+		notice, ok := mailer.last("alice@example.com")
+		require.True(t, ok)
+		assert.Equal(t, "Your email address is being changed", notice.Subject)
+		msg, ok := mailer.last("alice@example.org")
+		require.True(t, ok)
+		match := mailTokenPattern.FindStringSubmatch(msg.Body)
+		require.NotNil(t, match)
+		token, err := url.QueryUnescape(match[1])
+		require.NoError(t, err)
+
+		// Nothing changes until the new address is confirmed
+		assert.Equal(t, "alice@example.com", me(session.Token).Email)
+
+		resp = do(http.MethodGet, "/confirm-email?token="+url.QueryEscape(token), "", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = do(http.MethodGet, "/confirm-email?token="+url.QueryEscape(token), "", "")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		user := me(session.Token)
+		assert.Equal(t, "alice@example.org", user.Email)
+		assert.True(t, user.EmailVerified)
+		assert.Empty(t, user.PendingEmail)
+		assert.Equal(t, http.StatusOK, login("alice@example.org", "new-secret").StatusCode)
+	})
+
+	t.Run("delete account", func(t *testing.T) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, err := writer.CreateFormFile("file", "notes.txt")
+		require.NoError(t, err)
+		part.Write([]byte("to be purged"))
+		writer.Close()
+		req := httptest.NewRequest(http.MethodPost, "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+session.Token)
+		resp, err := app.Fiber.Test(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		userID := me(session.Token).ID.Hex()
+		owned, err := files.ListOwned(context.Background(), userID, 10)
+		require.NoError(t, err)
+		require.Len(t, owned, 1)
+		fileID := owned[0].FileID
+
+		resp = do(http.MethodDelete, "/me", session.Token, `{"password":"wrong"}`)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		resp = do(http.MethodDelete, "/me", session.Token, `{"password":"new-secret"}`)
+		require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+		// The account is gone for the user straight away
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/files", session.Token, "").StatusCode)
+		assert.Equal(t, http.StatusUnauthorized, login("alice@example.org", "new-secret").StatusCode)
+
+		purger := &jobs.AccountPurger{
+			Users:   users,
+			Files:   files,
+			Storage: app.Files.Storage,
+		}
+		purger.Run(context.Background())
+
+		owned, err = files.ListOwned(context.Background(), userID, 10)
+		require.NoError(t, err)
+		assert.Empty(t, owned)
+		_, err = app.Files.Storage.Stat(context.Background(), fileID)
+		assert.Error(t, err)
+		deleted, err := users.ListDeleted(context.Background())
+		require.NoError(t, err)
+		assert.Empty(t, deleted)
+
+		// Bob is untouched
+		assert.Equal(t, http.StatusOK, login("bob@example.com", "secret-123").StatusCode)
+	})
+}
+
+// racingUsers never sees an existing account in its checks, like a request
+// racing another one for the same email address or username.
+type racingUsers struct {
+	*fakeUsers
+}
+
+func (racingUsers) EmailExists(ctx context.Context, email string) (bool, error) {
+	return false, nil
+}
+
+func (racingUsers) UsernameExists(ctx context.Context, username string) (bool, error) {
+	return false, nil
+}
+
+func TestUniqueAccounts(t *testing.T) {
+	t.Run("races are conflicts", func(t *testing.T) {
+		app, _, _ := newTestAppWith(func(deps *server.Dependencies) {
+			deps.Users = racingUsers{&fakeUsers{}}
+		})
+		client := testClient{t: t, app: app}
+		client.signup("alice")
+		bob := client.signup("bob")
+
+		resp := client.do(http.MethodPost, "/register", "", `{"email":"alice@example.com","username":"alice2","password":"secret-123"}`)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		resp = client.do(http.MethodPost, "/register", "", `{"email":"alice2@example.com","username":"alice","password":"secret-123"}`)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		resp = client.do(http.MethodPatch, "/me", bob, `{"username":"alice"}`)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	})
+
+	t.Run("emails are compared in any case", func(t *testing.T) {
+		app, users, _ := newTestApp()
+		client := testClient{t: t, app: app}
+		resp := client.do(http.MethodPost, "/register", "", `{"email":" Alice@Example.com ","username":"alice","password":"secret-123"}`)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		_, err := users.GetByEmail(context.Background(), "alice@example.com")
+		require.NoError(t, err, "email stored as typed")
+
+		resp = client.do(http.MethodPost, "/register", "", `{"email":"alice@example.com","username":"alice2","password":"secret-123"}`)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		resp = client.do(http.MethodPost, "/login", "", `{"email":"ALICE@example.com","password":"secret-123"}`)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("deleted accounts hold their names until purged", func(t *testing.T) {
+		app, users, files := newTestApp()
+		client := testClient{t: t, app: app}
+		alice := client.signup("alice")
+		require.Equal(t, http.StatusAccepted, client.do(http.MethodDelete, "/me", alice, `{"password":"secret-123"}`).StatusCode)
+
+		listed, err := users.List(context.Background(), 0, 0)
+		require.NoError(t, err)
+		assert.Empty(t, listed)
+
+		register := func() int {
+			return client.do(http.MethodPost, "/register", "", `{"email":"alice@example.com","username":"alice","password":"secret-123"}`).StatusCode
+		}
+		assert.Equal(t, http.StatusConflict, register())
+
+		purger := &jobs.AccountPurger{Users: users, Files: files, Storage: app.Files.Storage}
+		purger.Run(context.Background())
+		assert.Equal(t, http.StatusOK, register())
+	})
+}
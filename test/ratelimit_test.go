@@ -0,0 +1,183 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"trademarkia/ratelimit"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRate(t *testing.T) {
+	rate, err := ratelimit.ParseRate("10/1m")
+	require.NoError(t, err)
+	assert.Equal(t, ratelimit.Rate{Limit: 10, Window: time.Minute}, rate)
+
+	rate, err = ratelimit.ParseRate("0")
+	require.NoError(t, err)
+	assert.True(t, rate.Disabled())
+
+	for _, invalid := range []string{"10", "ten/1m", "10/soon", "10/-1m"} {
+		_, err := ratelimit.ParseRate(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(ctx context.Context, key string, rate ratelimit.Rate) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("connection refused")
+}
+
+func (failingLimiter) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	return time.Time{}, errors.New("connection refused")
+}
+
+func (failingLimiter) Fail(ctx context.Context, key string, lockout ratelimit.Lockout) (time.Time, error) {
+	return time.Time{}, errors.New("connection refused")
+}
+
+func (failingLimiter) Reset(ctx context.Context, key string) error {
+	return errors.New("connection refused")
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	ctx := context.Background()
+	rate := ratelimit.Rate{Limit: 3, Window: time.Minute}
+
+	for _, limiter := range []ratelimit.Limiter{
+		ratelimit.NewMemoryLimiter(),
+		ratelimit.NewFallback(failingLimiter{}, ratelimit.NewMemoryLimiter()),
+	} {
+		for i := 0; i < 3; i++ {
+			result, err := limiter.Allow(ctx, "alice", rate)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed)
+			assert.Equal(t, 2-i, result.Remaining)
+		}
+
+		result, err := limiter.Allow(ctx, "alice", rate)
+		require.NoError(t, err)
+		assert.False(t, result.Allowed)
+		assert.True(t, result.RetryAfter > 0 && result.RetryAfter <= time.Minute)
+
+		result, err = limiter.Allow(ctx, "bob", rate)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+	}
+}
+
+func TestLockouts(t *testing.T) {
+	ctx := context.Background()
+	lockout := ratelimit.Lockout{Threshold: 2, Base: time.Minute, Max: 3 * time.Minute, Memory: time.Hour}
+
+	for _, limiter := range []ratelimit.Limiter{
+		ratelimit.NewMemoryLimiter(),
+		ratelimit.NewFallback(failingLimiter{}, ratelimit.NewMemoryLimiter()),
+	} {
+		until, err := limiter.Fail(ctx, "alice", lockout)
+		require.NoError(t, err)
+		assert.True(t, until.IsZero())
+
+		// The second failure locks for Base, and every further one doubles it
+		// up to Max
+		for _, d := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute} {
+			until, err = limiter.Fail(ctx, "alice", lockout)
+			require.NoError(t, err)
+			assert.WithinDuration(t, time.Now().Add(d), until, 2*time.Second)
+		}
+		locked, err := limiter.LockedUntil(ctx, "alice")
+		require.NoError(t, err)
+		assert.Equal(t, until, locked)
+
+		locked, err = limiter.LockedUntil(ctx, "bob")
+		require.NoError(t, err)
+		assert.True(t, locked.IsZero())
+
+		// Resetting forgets the failures, so the count starts over
+		require.NoError(t, limiter.Reset(ctx, "alice"))
+		until, err = limiter.Fail(ctx, "alice", lockout)
+		require.NoError(t, err)
+		assert.True(t, until.IsZero())
+	}
+}
+
+func TestLoginRateLimits(t *testing.T) {
+	app, _, _ := newTestApp()
+
+	do := func(path, body string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Fiber.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+	login := func(email, password string) *http.Response {
+		return do("/login", `{"email":"`+email+`","password":"`+password+`"}`)
+	}
+
+	resp := do("/register", `{"email":"alice@example.com","username":"alice","password":"secret-123"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	t.Run("lockout", func(t *testing.T) {
+		for i := 0; i < 4; i++ {
+			assert.Equal(t, http.StatusUnauthorized, login("alice@example.com", "guess").StatusCode)
+		}
+
+		resp := login("alice@example.com", "guess")
+		require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+		var body struct {
+			Error struct {
+				Code    string `json:"code"`
+				Details struct {
+					RetryAfter  int       `json:"retry_after"`
+					LockedUntil time.Time `json:"locked_until"`
+				} `json:"details"`
+			} `json:"error"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, "too_many_requests", body.Error.Code)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), body.Error.Details.LockedUntil, 2*time.Second)
+
+		// Even the right password is refused until the lockout ends, and
+		// unknown accounts are locked the same way
+		assert.Equal(t, http.StatusTooManyRequests, login("alice@example.com", "secret-123").StatusCode)
+		for i := 0; i < 4; i++ {
+			assert.Equal(t, http.StatusUnauthorized, login("nobody@example.com", "guess").StatusCode)
+		}
+		assert.Equal(t, http.StatusTooManyRequests, login("nobody@example.com", "guess").StatusCode)
+	})
+
+	t.Run("form bodies", func(t *testing.T) {
+		// The per-account limit counts logins however the body is encoded
+		form := func() *http.Response {
+			req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader("email=carol%40example.com&password=guess"))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			resp, err := app.Fiber.Test(req)
+			require.NoError(t, err)
+			return resp
+		}
+		resp := form()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, "9", resp.Header.Get("X-RateLimit-Remaining"))
+	})
+
+	t.Run("per IP", func(t *testing.T) {
+		// 12 logins so far, of the 20 a minute allowed from one address
+		for i := 0; i < 8; i++ {
+			login(fmt.Sprintf("user%d@example.com", i), "guess")
+		}
+		resp := login("bob@example.com", "guess")
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.Equal(t, "0", resp.Header.Get("X-RateLimit-Remaining"))
+	})
+}
@@ -1,37 +1,140 @@
 package test
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
-	"trademarkia/handlers"
+	"time"
+	"trademarkia/models"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSearchFilesHandler(t *testing.T) {
 	// Setup Fiber app
 	app := fiber.New()
+	services, _, files := newTestApp()
+	for _, name := range []string{"check.jpg", "other.png"} {
+		require.NoError(t, files.Create(context.Background(), &models.File{
+			FileID:     name,
+			Filename:   name,
+			UploadDate: time.Now(),
+			UserID:     "test-user",
+		}))
+	}
 
 	// Register route with SearchFilesHandler
 	app.Get("/search", func(c *fiber.Ctx) error {
 		// Mock userID in the context
-		c.Locals("userID", "valid User_ID") // Replace this with a valid test user ID
-		return handlers.SearchFilesHandler(c)
+		c.Locals("userID", "test-user")
+		return services.Files.SearchFilesHandler(c)
 	})
 
-	// Create test request to search files
-	req := httptest.NewRequest(http.MethodGet, "/search?name=check.jpg&limit=10&offset=0", nil)
-	req.Header.Set("Authorization", "Bearer valid-jwt-token") // Mocking a valid JWT token for authenticated request
-	// Create a test response recorder
-	resp, err := app.Test(req)
-	if err != nil {
-		t.Fatalf("Failed to send test request: %v", err)
+	search := func() []models.File {
+		// Create test request to search files
+		req := httptest.NewRequest(http.MethodGet, "/search?name=check.jpg&limit=10&offset=0", nil)
+		req.Header.Set("Authorization", "Bearer valid-jwt-token") // Mocking a valid JWT token for authenticated request
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to send test request: %v", err)
+		}
+
+		// Assert that the status code is 200 OK
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var result []models.File
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return result
+	}
+
+	result := search()
+	require.Len(t, result, 1)
+	assert.Equal(t, "check.jpg", result[0].Filename)
+
+	// The second identical search is answered from the cache
+	result = search()
+	require.Len(t, result, 1)
+	assert.Equal(t, 1, files.searches)
+}
+
+func TestSearchValidation(t *testing.T) {
+	app, _, _ := newTestApp()
+	client := testClient{t: t, app: app}
+	alice := client.signup("alice")
+	client.upload(alice, "check.jpg", "a cheque")
+
+	for _, query := range []string{"limit=0", "limit=101", "limit=-1", "offset=-1", "date=yesterday", "date=2024-13-01", "view=everyone"} {
+		resp := client.do(http.MethodGet, "/search?"+query, alice, "")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+
+	today := time.Now()
+	for _, date := range []string{today.Format(time.DateOnly), today.Format("2006-01-02") + "%2012:00:00.5", today.Format(time.RFC3339)} {
+		var found []models.File
+		client.read(client.do(http.MethodGet, "/search?limit=100&date="+date, alice, ""), &found)
+		assert.Len(t, found, 1, date)
+	}
+}
+
+func TestSearchCacheInvalidation(t *testing.T) {
+	app, _, files := newTestApp()
+	client := testClient{t: t, app: app}
+	alice := client.signup("alice")
+	bob := client.signup("bob")
+	fileID := client.upload(alice, "check.jpg", "a cheque")
+
+	search := func(token string) []string {
+		var found []models.File
+		client.read(client.do(http.MethodGet, "/search?name=check", token, ""), &found)
+		names := []string{}
+		for _, file := range found {
+			names = append(names, file.Filename)
+		}
+		return names
 	}
+	queries := func() int {
+		files.mu.Lock()
+		defer files.mu.Unlock()
+		return files.searches
+	}
+
+	assert.Equal(t, []string{"check.jpg"}, search(alice))
+	assert.Equal(t, []string{"check.jpg"}, search(alice))
+	assert.Equal(t, 1, queries())
 
-	// Assert that the status code is 200 OK
-	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	// Every change to alice's files bumps her cache generation
+	mutations := []func(){
+		func() { client.upload(alice, "check-2.jpg", "another cheque") },
+		func() {
+			resp := client.do(http.MethodPatch, "/files/"+fileID, alice, `{"filename":"checked.jpg"}`)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+		},
+		func() { client.read(client.do(http.MethodDelete, "/files/"+fileID, alice, ""), &struct{}{}) },
+		func() { client.read(client.do(http.MethodPost, "/trash/"+fileID+"/restore", alice, ""), &struct{}{}) },
+	}
+	expected := [][]string{
+		{"check-2.jpg", "check.jpg"},
+		{"check-2.jpg", "checked.jpg"},
+		{"check-2.jpg"},
+		{"check-2.jpg", "checked.jpg"},
+	}
+	for i, mutate := range mutations {
+		before := queries()
+		mutate()
+		assert.ElementsMatch(t, expected[i], search(alice))
+		assert.Equal(t, before+1, queries())
+		search(alice)
+		assert.Equal(t, before+1, queries(), "repeated search after mutation %d should be cached", i)
+	}
 
-	// Optionally: Add more assertions based on the response body or headers
+	// Bob's cached results are his own and unaffected
+	assert.Empty(t, search(bob))
+	before := queries()
+	client.upload(alice, "check-3.jpg", "a third cheque")
+	assert.Empty(t, search(bob))
+	assert.Equal(t, before, queries())
 }
@@ -0,0 +1,110 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sessionList struct {
+	Sessions []struct {
+		ID        string `json:"id"`
+		UserAgent string `json:"user_agent"`
+		IP        string `json:"ip"`
+		Current   bool   `json:"current"`
+	} `json:"sessions"`
+}
+
+func TestSessions(t *testing.T) {
+	app, _, _ := newTestApp()
+
+	do := func(method, path, token, userAgent, body string) *http.Response {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if userAgent != "" {
+			req.Header.Set("User-Agent", userAgent)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := app.Fiber.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+	login := func(email, userAgent string) tokenPair {
+		return decode(t, do(http.MethodPost, "/login", "", userAgent, `{"email":"`+email+`","password":"secret-123"}`))
+	}
+	list := func(token string) sessionList {
+		resp := do(http.MethodGet, "/sessions", token, "", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var sessions sessionList
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&sessions))
+		return sessions
+	}
+
+	for _, user := range []string{"alice", "bob"} {
+		resp := do(http.MethodPost, "/register", "", "", `{"email":"`+user+`@example.com","username":"`+user+`","password":"secret-123"}`)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	laptop := login("alice@example.com", "Laptop")
+	phone := login("alice@example.com", "Phone")
+
+	sessions := list(laptop.Token)
+	require.Len(t, sessions.Sessions, 2)
+	var phoneSession string
+	for _, session := range sessions.Sessions {
+		assert.NotEmpty(t, session.IP)
+		assert.Equal(t, session.UserAgent == "Laptop", session.Current)
+		if session.UserAgent == "Phone" {
+			phoneSession = session.ID
+		}
+	}
+	require.NotEmpty(t, phoneSession)
+
+	t.Run("other users' sessions cannot be revoked", func(t *testing.T) {
+		bob := login("bob@example.com", "")
+		assert.Len(t, list(bob.Token).Sessions, 1)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/sessions/"+phoneSession, bob.Token, "", "").StatusCode)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/sessions/unknown", bob.Token, "", "").StatusCode)
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/files", phone.Token, "", "").StatusCode)
+	})
+
+	t.Run("revoke one session", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, do(http.MethodDelete, "/sessions/"+phoneSession, laptop.Token, "", "").StatusCode)
+
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/files", phone.Token, "", "").StatusCode)
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/token/refresh", "", "", `{"refresh_token":"`+phone.RefreshToken+`"}`).StatusCode)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/sessions/"+phoneSession, laptop.Token, "", "").StatusCode)
+
+		sessions := list(laptop.Token)
+		require.Len(t, sessions.Sessions, 1)
+		assert.True(t, sessions.Sessions[0].Current)
+	})
+
+	t.Run("refreshing keeps the session", func(t *testing.T) {
+		refreshed := decode(t, do(http.MethodPost, "/token/refresh", "", "", `{"refresh_token":"`+laptop.RefreshToken+`"}`))
+		sessions := list(refreshed.Token)
+		require.Len(t, sessions.Sessions, 1)
+		assert.True(t, sessions.Sessions[0].Current)
+		laptop = refreshed
+	})
+
+	t.Run("log out everywhere", func(t *testing.T) {
+		tablet := login("alice@example.com", "Tablet")
+
+		assert.Equal(t, http.StatusOK, do(http.MethodDelete, "/sessions", tablet.Token, "", "").StatusCode)
+
+		for _, pair := range []tokenPair{laptop, tablet} {
+			assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/files", pair.Token, "", "").StatusCode)
+			assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/token/refresh", "", "", `{"refresh_token":"`+pair.RefreshToken+`"}`).StatusCode)
+		}
+		// Only the new login is left
+		assert.Len(t, list(login("alice@example.com", "").Token).Sessions, 1)
+	})
+}
@@ -0,0 +1,160 @@
+package test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+	"trademarkia/config"
+	"trademarkia/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublicShares(t *testing.T) {
+	app, _, _ := newTestApp()
+	client := testClient{t: t, app: app}
+	alice := client.signup("alice")
+	fileID := client.upload(alice, "notes.txt", "shared notes")
+
+	createShare := func(body string) string {
+		var created struct {
+			Token string `json:"token"`
+		}
+		resp := client.do(http.MethodPost, "/files/"+fileID+"/shares", alice, body)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+		return created.Token
+	}
+	downloads := func() map[bool]int {
+		var shares []models.Share
+		client.read(client.do(http.MethodGet, "/files/"+fileID+"/shares", alice, ""), &shares)
+		counts := map[bool]int{}
+		for _, share := range shares {
+			counts[share.Protected] = share.DownloadCount
+		}
+		return counts
+	}
+
+	t.Run("password and download limit", func(t *testing.T) {
+		token := createShare(`{"password":"hunter2","max_downloads":2}`)
+		get := func(headers ...string) *http.Response {
+			return client.do(http.MethodGet, "/s/"+token, "", "", headers...)
+		}
+
+		resp := get()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, "60", resp.Header.Get("X-RateLimit-Limit"))
+		assert.Equal(t, http.StatusUnauthorized, get("X-Share-Password", "wrong").StatusCode)
+		// The password is only read from the header, never the URL
+		assert.Equal(t, http.StatusUnauthorized, client.do(http.MethodGet, "/s/"+token+"?password=hunter2", "", "").StatusCode)
+
+		resp = get("X-Share-Password", "hunter2")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "shared notes", string(data))
+		assert.Equal(t, 1, downloads()[true])
+
+		// Range and conditional requests do not use up downloads
+		resp = get("X-Share-Password", "hunter2", "Range", "bytes=0-5")
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		resp = get("X-Share-Password", "hunter2", "If-None-Match", resp.Header.Get("ETag"))
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+		assert.Equal(t, 1, downloads()[true])
+
+		assert.Equal(t, http.StatusOK, get("X-Share-Password", "hunter2").StatusCode)
+		assert.Equal(t, http.StatusGone, get("X-Share-Password", "hunter2").StatusCode)
+		assert.Equal(t, 2, downloads()[true])
+	})
+
+	t.Run("wrong passwords lock the share", func(t *testing.T) {
+		token := createShare(`{"password":"correct horse"}`)
+		get := func(password string) *http.Response {
+			return client.do(http.MethodGet, "/s/"+token, "", "", "X-Share-Password", password)
+		}
+		for i := 0; i < 4; i++ {
+			assert.Equal(t, http.StatusUnauthorized, get("guess").StatusCode)
+		}
+		assert.Equal(t, http.StatusTooManyRequests, get("guess").StatusCode)
+		assert.Equal(t, http.StatusTooManyRequests, get("correct horse").StatusCode)
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		var created struct {
+			ShareID string `json:"share_id"`
+			Token   string `json:"token"`
+		}
+		resp := client.do(http.MethodPost, "/files/"+fileID+"/shares", alice, `{}`)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+		assert.Equal(t, http.StatusOK, client.do(http.MethodGet, "/s/"+created.Token, "", "").StatusCode)
+
+		resp = client.do(http.MethodDelete, "/files/"+fileID+"/shares/"+created.ShareID, alice, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, http.StatusGone, client.do(http.MethodGet, "/s/"+created.Token, "", "").StatusCode)
+		assert.Equal(t, http.StatusNotFound, client.do(http.MethodGet, "/s/not-a-token", "", "").StatusCode)
+	})
+}
+
+func TestShareLinks(t *testing.T) {
+	app, _, _ := newTestApp()
+	client := testClient{t: t, app: app}
+	alice := client.signup("alice")
+	bob := client.signup("bob")
+	fileID := client.upload(alice, "notes.txt", "linked notes")
+
+	create := func(ttl string) models.ShareLink {
+		resp := client.do(http.MethodPost, "/share/"+fileID+"?ttl="+ttl, alice, "")
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		var link models.ShareLink
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&link))
+		return link
+	}
+	lifetime := func(link models.ShareLink) time.Duration {
+		return link.ExpiresAt.Sub(link.CreatedAt)
+	}
+
+	assert.Equal(t, 2*time.Hour, lifetime(create("2h")))
+	assert.Equal(t, 90*time.Second, lifetime(create("90")))
+	assert.Equal(t, config.SHARE_LINK_DEFAULT_TTL, lifetime(create("")))
+	// Longer lifetimes are cut down to the maximum
+	assert.Equal(t, config.SHARE_LINK_MAX_TTL, lifetime(create("100000h")))
+
+	for ttl, message := range map[string]string{
+		"0":    "ttl must be positive",
+		"-1h":  "ttl must be positive",
+		"soon": "ttl must be a duration such as 2h or a number of seconds",
+	} {
+		resp := client.do(http.MethodPost, "/share/"+fileID+"?ttl="+ttl, alice, "")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, ttl)
+		var body struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, message, body.Error.Message, ttl)
+	}
+	assert.Equal(t, http.StatusNotFound, client.do(http.MethodPost, "/share/"+fileID, bob, "").StatusCode)
+
+	// The lifetime can be sent in the body instead, which wins over the query
+	resp := client.do(http.MethodPost, "/share/"+fileID+"?ttl=1h", alice, `{"ttl":"30m"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var link models.ShareLink
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&link))
+	assert.Equal(t, 30*time.Minute, lifetime(link))
+	assert.Equal(t, http.StatusBadRequest, client.do(http.MethodPost, "/share/"+fileID, alice, `{"ttl":"soon"}`).StatusCode)
+
+	// GET still mints links for older clients
+	resp = client.do(http.MethodGet, "/share/"+fileID+"?ttl=2h", alice, "")
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&link))
+	assert.Equal(t, 2*time.Hour, lifetime(link))
+
+	var links []models.ShareLink
+	client.read(client.do(http.MethodGet, "/share/"+fileID+"/links", alice, ""), &links)
+	assert.Len(t, links, 6)
+}
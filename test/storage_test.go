@@ -0,0 +1,145 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sync"
+	"testing"
+	"time"
+	"trademarkia/storage"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageBackends(t *testing.T) {
+	signer := storage.NewURLSigner("http://localhost:8080", "test-secret")
+	local, err := storage.NewLocalStorage(t.TempDir(), signer)
+	require.NoError(t, err)
+
+	backends := map[string]storage.Storage{
+		"memory": storage.NewMemoryStorage(signer),
+		"local":  local,
+	}
+	for name, backend := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			content := []byte("This is a test file content")
+
+			err := backend.Put(ctx, "file-1", bytes.NewReader(content), storage.PutOptions{
+				ContentType: "text/plain",
+				Metadata:    map[string]string{"sha256": "abc"},
+			})
+			require.NoError(t, err)
+
+			body, info, err := backend.Get(ctx, "file-1")
+			require.NoError(t, err)
+			data, err := io.ReadAll(body)
+			body.Close()
+			require.NoError(t, err)
+			assert.Equal(t, content, data)
+			assert.Equal(t, int64(len(content)), info.Size)
+			assert.Equal(t, "text/plain", info.ContentType)
+			assert.NotEmpty(t, info.ETag)
+			assert.Equal(t, "abc", info.Metadata["sha256"])
+
+			objects, err := backend.List(ctx, "file-")
+			require.NoError(t, err)
+			assert.Len(t, objects, 1)
+
+			link, err := backend.PresignGet(ctx, "file-1", time.Minute)
+			require.NoError(t, err)
+			u, err := url.Parse(link)
+			require.NoError(t, err)
+			verifier := backend.(storage.SignedURLVerifier)
+			assert.NoError(t, verifier.VerifySignedURL(path.Base(u.Path), u.Query().Get("expires"), u.Query().Get("signature")))
+			assert.ErrorIs(t, verifier.VerifySignedURL("file-2", u.Query().Get("expires"), u.Query().Get("signature")), storage.ErrInvalidSignature)
+
+			require.NoError(t, backend.Delete(ctx, "file-1"))
+			require.NoError(t, backend.Delete(ctx, "file-1"))
+			_, err = backend.Stat(ctx, "file-1")
+			assert.ErrorIs(t, err, storage.ErrNotFound)
+		})
+	}
+}
+
+// stubS3 accepts multipart uploads in memory. Parts finish in reverse order,
+// and failPart makes that part's upload fail.
+type stubS3 struct {
+	storage.S3Client
+
+	mu        sync.Mutex
+	failPart  int32
+	parts     map[int32][]byte
+	completed *s3.CompleteMultipartUploadInput
+	aborted   bool
+}
+
+func (s *stubS3) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload-1")}, nil
+}
+
+func (s *stubS3) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	partNumber := aws.ToInt32(params.PartNumber)
+	data, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	time.Sleep(time.Duration(5-partNumber) * 10 * time.Millisecond)
+	if partNumber == s.failPart {
+		return nil, errors.New("connection reset")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.parts[partNumber] = data
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf("etag-%d", partNumber))}, nil
+}
+
+func (s *stubS3) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	s.completed = params
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (s *stubS3) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	s.aborted = true
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func TestS3MultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	// Two full 10 MB parts and a short last one
+	content := bytes.Repeat([]byte("0123456789"), 2_500_000)
+
+	client := &stubS3{parts: map[int32][]byte{}}
+	backend := storage.NewS3StorageWithClient(client, "bucket", "eu-north-1")
+	require.NoError(t, backend.Put(ctx, "file-1", bytes.NewReader(content), storage.PutOptions{}))
+
+	// Parts are completed in part number order whatever order they finished in
+	require.NotNil(t, client.completed)
+	assert.False(t, client.aborted)
+	parts := client.completed.MultipartUpload.Parts
+	require.Len(t, parts, 3)
+	var uploaded []byte
+	for i, part := range parts {
+		assert.Equal(t, int32(i+1), aws.ToInt32(part.PartNumber))
+		assert.Equal(t, fmt.Sprintf("etag-%d", i+1), aws.ToString(part.ETag))
+		uploaded = append(uploaded, client.parts[int32(i+1)]...)
+	}
+	assert.Equal(t, content, uploaded)
+
+	// A failed part aborts the upload instead of completing it
+	client = &stubS3{parts: map[int32][]byte{}, failPart: 2}
+	backend = storage.NewS3StorageWithClient(client, "bucket", "eu-north-1")
+	err := backend.Put(ctx, "file-2", bytes.NewReader(content), storage.PutOptions{})
+	assert.ErrorContains(t, err, "upload part 2")
+	assert.True(t, client.aborted)
+	assert.Nil(t, client.completed)
+}
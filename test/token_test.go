@@ -0,0 +1,83 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tokenPair struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+func decode(t *testing.T, resp *http.Response) tokenPair {
+	t.Helper()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var pair tokenPair
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&pair))
+	require.NotEmpty(t, pair.Token)
+	require.NotEmpty(t, pair.RefreshToken)
+	return pair
+}
+
+func TestTokenLifecycle(t *testing.T) {
+	app, _, _ := newTestApp()
+
+	do := func(method, path, token, body string) *http.Response {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := app.Fiber.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+	refresh := func(refreshToken string) *http.Response {
+		return do(http.MethodPost, "/token/refresh", "", `{"refresh_token":"`+refreshToken+`"}`)
+	}
+	login := func() tokenPair {
+		return decode(t, do(http.MethodPost, "/login", "", `{"email":"alice@example.com","password":"secret-123"}`))
+	}
+
+	resp := do(http.MethodPost, "/register", "", `{"email":"alice@example.com","username":"alice","password":"secret-123"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	t.Run("rotation and reuse detection", func(t *testing.T) {
+		first := login()
+		assert.Greater(t, first.ExpiresIn, 0)
+
+		second := decode(t, refresh(first.RefreshToken))
+		assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/files", second.Token, "").StatusCode)
+
+		// Replaying the first refresh token kills the whole family
+		assert.Equal(t, http.StatusUnauthorized, refresh(first.RefreshToken).StatusCode)
+		assert.Equal(t, http.StatusUnauthorized, refresh(second.RefreshToken).StatusCode)
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/files", second.Token, "").StatusCode)
+	})
+
+	t.Run("logout", func(t *testing.T) {
+		pair := login()
+		other := login()
+
+		assert.Equal(t, http.StatusOK, do(http.MethodPost, "/logout", pair.Token, "").StatusCode)
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/files", pair.Token, "").StatusCode)
+		assert.Equal(t, http.StatusUnauthorized, refresh(pair.RefreshToken).StatusCode)
+
+		// Other sessions are not affected
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/files", other.Token, "").StatusCode)
+		decode(t, refresh(other.RefreshToken))
+	})
+
+	t.Run("unknown refresh token", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, refresh("not-a-token").StatusCode)
+	})
+}
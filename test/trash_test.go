@@ -0,0 +1,73 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+	"trademarkia/jobs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrash(t *testing.T) {
+	app, _, files := newTestApp()
+	client := testClient{t: t, app: app}
+	alice := client.signup("alice")
+	bob := client.signup("bob")
+	fileID := client.upload(alice, "report.pdf", "quarterly report")
+
+	listed := func(path string) []string {
+		var found []struct {
+			FileID string `json:"file_id"`
+		}
+		client.read(client.do(http.MethodGet, path, alice, ""), &found)
+		ids := []string{}
+		for _, file := range found {
+			ids = append(ids, file.FileID)
+		}
+		return ids
+	}
+	trash := func() time.Time {
+		var trashed struct {
+			PurgeAt time.Time `json:"purge_at"`
+		}
+		client.read(client.do(http.MethodDelete, "/files/"+fileID, alice, ""), &trashed)
+		return trashed.PurgeAt
+	}
+
+	t.Run("trash and restore", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, client.do(http.MethodDelete, "/files/"+fileID, bob, "").StatusCode)
+
+		trash()
+		assert.Empty(t, listed("/files"))
+		assert.Equal(t, []string{fileID}, listed("/trash"))
+		assert.Equal(t, http.StatusNotFound, client.do(http.MethodGet, "/files/"+fileID+"/content", alice, "").StatusCode)
+
+		assert.Equal(t, http.StatusNotFound, client.do(http.MethodPost, "/trash/"+fileID+"/restore", bob, "").StatusCode)
+		assert.Equal(t, http.StatusOK, client.do(http.MethodPost, "/trash/"+fileID+"/restore", alice, "").StatusCode)
+		assert.Equal(t, []string{fileID}, listed("/files"))
+		assert.Empty(t, listed("/trash"))
+		assert.Equal(t, http.StatusNotFound, client.do(http.MethodPost, "/trash/"+fileID+"/restore", alice, "").StatusCode)
+	})
+
+	t.Run("purged at purge_at", func(t *testing.T) {
+		// Uploaded long enough ago for a live file to have expired already
+		files.mu.Lock()
+		files.files[fileID].UploadDate = time.Now().Add(-10 * 24 * time.Hour)
+		files.mu.Unlock()
+		purgeAt := trash()
+
+		deleter := &jobs.FileDeleter{Files: files, Storage: app.Files.Storage}
+		deleter.Run(context.Background(), purgeAt.Add(-time.Minute))
+		assert.Equal(t, []string{fileID}, listed("/trash"))
+		_, err := app.Files.Storage.Stat(context.Background(), fileID)
+		require.NoError(t, err)
+
+		deleter.Run(context.Background(), purgeAt.Add(time.Minute))
+		assert.Empty(t, listed("/trash"))
+		_, err = app.Files.Storage.Stat(context.Background(), fileID)
+		assert.Error(t, err)
+	})
+}
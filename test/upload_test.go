@@ -2,22 +2,37 @@ package test
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"trademarkia/handlers"
+	"trademarkia/models"
+	"trademarkia/server"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestUploadHandler(t *testing.T) {
 	// Setup Fiber app
 	app := fiber.New()
+	services, _, files := newTestApp()
 
 	// Register the route with UploadHandler
-	app.Post("/upload", handlers.UploadHandler)
+	app.Post("/upload", func(c *fiber.Ctx) error {
+		// Mock userID in the context
+		c.Locals("userID", "test-user")
+		return services.Files.UploadHandler(c)
+	})
 
 	// Create a test form file to simulate an upload
 	body := &bytes.Buffer{}
@@ -42,6 +57,92 @@ func TestUploadHandler(t *testing.T) {
 
 	// Assert that the status code is 200 OK
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var result models.File
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
 
-	// Optionally: Add more assertions based on the response body or headers
+	// The metadata and the content must both have been stored
+	uploaded, err := files.ListByUser(context.Background(), "test-user", false)
+	require.NoError(t, err)
+	require.Len(t, uploaded, 1)
+	assert.Equal(t, "testfile.jpg", uploaded[0].Filename)
+
+	// Size, type and checksum come from the content, not the .jpg extension
+	sum := sha256.Sum256([]byte("This is a test file content"))
+	assert.Equal(t, int64(len("This is a test file content")), uploaded[0].FileSize)
+	assert.Equal(t, "text/plain; charset=utf-8", uploaded[0].ContentType)
+	assert.Equal(t, hex.EncodeToString(sum[:]), uploaded[0].SHA256)
+	assert.Equal(t, uploaded[0].FileID, result.FileID)
+	assert.Equal(t, "testfile.jpg", result.Filename)
+	assert.Equal(t, "test-user", result.UserID)
+	assert.Equal(t, "owner", result.Permission)
+	assert.Equal(t, 1, result.Version)
+	assert.Equal(t, uploaded[0].FileSize, result.FileSize)
+	assert.Equal(t, uploaded[0].ContentType, result.ContentType)
+	assert.Equal(t, uploaded[0].SHA256, result.SHA256)
+
+	info, err := services.Files.Storage.Stat(context.Background(), uploaded[0].FileID)
+	require.NoError(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", info.ContentType)
+
+	content, _, err := services.Files.Storage.Get(context.Background(), uploaded[0].FileID)
+	require.NoError(t, err)
+	defer content.Close()
+	data, err := io.ReadAll(content)
+	require.NoError(t, err)
+	assert.Equal(t, "This is a test file content", string(data))
+}
+
+func TestUploadChecksumCoversWholeFile(t *testing.T) {
+	app, _, _ := newTestApp()
+	client := testClient{t: t, app: app}
+	alice := client.signup("alice")
+
+	// Longer than the part read to sniff the content type
+	content := strings.Repeat("0123456789", 200)
+	client.upload(alice, "digits.txt", content)
+
+	var files []models.File
+	client.read(client.do(http.MethodGet, "/files", alice, ""), &files)
+	require.Len(t, files, 1)
+	sum := sha256.Sum256([]byte(content))
+	assert.Equal(t, hex.EncodeToString(sum[:]), files[0].SHA256)
+	assert.Equal(t, int64(len(content)), files[0].FileSize)
+}
+
+// failingFiles stores nothing, as if the database were down.
+type failingFiles struct {
+	*fakeFiles
+}
+
+func (failingFiles) Create(ctx context.Context, file *models.File) error {
+	return errors.New("database unavailable")
+}
+
+func TestUploadRemovesObjectWhenMetadataFails(t *testing.T) {
+	services, _, _ := newTestAppWith(func(deps *server.Dependencies) {
+		deps.Files = failingFiles{&fakeFiles{files: map[string]*models.File{}}}
+	})
+	app := fiber.New(fiber.Config{ErrorHandler: handlers.ErrorHandler})
+	app.Post("/upload", func(c *fiber.Ctx) error {
+		c.Locals("userID", "test-user")
+		return services.Files.UploadHandler(c)
+	})
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", "notes.txt")
+	require.NoError(t, err)
+	part.Write([]byte("orphan"))
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	// The stored object is removed again rather than left without a row
+	objects, err := services.Files.Storage.List(context.Background(), "")
+	require.NoError(t, err)
+	assert.Empty(t, objects)
 }
@@ -0,0 +1,179 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"trademarkia/models"
+	"trademarkia/validation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestValidation(t *testing.T) {
+	app, _, _ := newTestApp()
+
+	do := func(method, path, token, body string) *http.Response {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := app.Fiber.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+	// invalidFields returns the rule each field broke
+	invalidFields := func(resp *http.Response) map[string]string {
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		var body struct {
+			Error struct {
+				Code    string `json:"code"`
+				Details struct {
+					Fields []validation.FieldError `json:"fields"`
+				} `json:"details"`
+			} `json:"error"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, "validation_error", body.Error.Code)
+		fields := map[string]string{}
+		for _, field := range body.Error.Details.Fields {
+			assert.NotEmpty(t, field.Message)
+			fields[field.Field] = field.Rule
+		}
+		return fields
+	}
+
+	t.Run("signup", func(t *testing.T) {
+		resp := do(http.MethodPost, "/register", "", `{"email":"","username":"`+strings.Repeat("a", 500)+`","password":"x"}`)
+		assert.Equal(t, map[string]string{"email": "required", "username": "max", "password": "min"}, invalidFields(resp))
+
+		resp = do(http.MethodPost, "/register", "", `{"email":"not-an-email","username":"al ice","password":"onlyletters"}`)
+		assert.Equal(t, map[string]string{"email": "email", "username": "username", "password": "password"}, invalidFields(resp))
+
+		resp = do(http.MethodPost, "/register", "", `{"email":"alice@example.com","username":"alice","password":"`+strings.Repeat("a1", 40)+`"}`)
+		assert.Equal(t, map[string]string{"password": "password"}, invalidFields(resp))
+
+		resp = do(http.MethodPost, "/register", "", `{"email":"alice@example.com","username":"alice","password":"secret-123"}`)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("login", func(t *testing.T) {
+		resp := do(http.MethodPost, "/login", "", `{"email":"alice@example.com"}`)
+		assert.Equal(t, map[string]string{"password": "required"}, invalidFields(resp))
+	})
+
+	session := decode(t, do(http.MethodPost, "/login", "", `{"email":"alice@example.com","password":"secret-123"}`))
+
+	t.Run("profile", func(t *testing.T) {
+		resp := do(http.MethodPatch, "/me", session.Token, `{"username":"","timezone":"Mars/Olympus_Mons"}`)
+		assert.Equal(t, map[string]string{"username": "min", "timezone": "timezone"}, invalidFields(resp))
+
+		// An empty timezone clears it
+		resp = do(http.MethodPatch, "/me", session.Token, `{"timezone":""}`)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("upload filenames are sanitised", func(t *testing.T) {
+		upload := func(filename string) *http.Response {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			part, err := writer.CreateFormFile("file", filename)
+			require.NoError(t, err)
+			part.Write([]byte("contents"))
+			writer.Close()
+			req := httptest.NewRequest(http.MethodPost, "/upload", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			req.Header.Set("Authorization", "Bearer "+session.Token)
+			resp, err := app.Fiber.Test(req)
+			require.NoError(t, err)
+			return resp
+		}
+
+		require.Equal(t, http.StatusOK, upload(`C:\fakepath\..\report.pdf`).StatusCode)
+		assert.Equal(t, map[string]string{"filename": "required"}, invalidFields(upload("..")))
+
+		resp := do(http.MethodGet, "/files", session.Token, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var files []models.File
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&files))
+		require.Len(t, files, 1)
+		assert.Equal(t, "report.pdf", files[0].Filename)
+
+		resp = do(http.MethodPatch, "/files/"+files[0].FileID, session.Token, `{"filename":"a/b.pdf"}`)
+		assert.Equal(t, map[string]string{"filename": "filename"}, invalidFields(resp))
+	})
+
+	t.Run("file, share, permission and API key bodies", func(t *testing.T) {
+		resp := do(http.MethodGet, "/files", session.Token, "")
+		var files []models.File
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&files))
+		require.Len(t, files, 1)
+		fileID := files[0].FileID
+		long := strings.Repeat("a", 73)
+
+		resp = do(http.MethodPatch, "/files/"+fileID, session.Token, `{"filename":"   ","version":0}`)
+		assert.Equal(t, map[string]string{"filename": "notblank", "version": "min"}, invalidFields(resp))
+
+		tags := []string{}
+		for i := 0; i <= 20; i++ {
+			tags = append(tags, fmt.Sprintf("tag-%d", i))
+		}
+		tagsJSON, err := json.Marshal(tags)
+		require.NoError(t, err)
+		resp = do(http.MethodPatch, "/files/"+fileID, session.Token, `{"tags":`+string(tagsJSON)+`}`)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		var body struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, "a file can have at most 20 tags", body.Error.Message)
+
+		resp = do(http.MethodPost, "/files/"+fileID+"/shares", session.Token, `{"password":"`+long+`","max_downloads":0}`)
+		assert.Equal(t, map[string]string{"password": "bcrypt", "max_downloads": "gt"}, invalidFields(resp))
+
+		resp = do(http.MethodPost, "/files/"+fileID+"/permissions", session.Token, `{"permission":"owner"}`)
+		assert.Equal(t, map[string]string{"user": "required", "permission": "oneof"}, invalidFields(resp))
+
+		resp = do(http.MethodPost, "/api-keys", session.Token, `{"name":"","expires_at":"2000-01-01T00:00:00Z"}`)
+		assert.Equal(t, map[string]string{"name": "required", "expires_at": "gt"}, invalidFields(resp))
+
+		resp = do(http.MethodDelete, "/me", session.Token, `{"password":"`+long+`"}`)
+		assert.Equal(t, map[string]string{"password": "bcrypt"}, invalidFields(resp))
+	})
+
+	t.Run("token and two-factor bodies", func(t *testing.T) {
+		assert.Equal(t, map[string]string{"refresh_token": "required"}, invalidFields(do(http.MethodPost, "/token/refresh", "", `{}`)))
+		assert.Equal(t, map[string]string{"token": "required"}, invalidFields(do(http.MethodPost, "/verify-email", "", `{}`)))
+		assert.Equal(t, map[string]string{"token": "required"}, invalidFields(do(http.MethodPost, "/confirm-email", "", `{}`)))
+		assert.Equal(t, map[string]string{"mfa_token": "required", "code": "required"}, invalidFields(do(http.MethodPost, "/login/mfa", "", `{}`)))
+
+		require.Equal(t, http.StatusOK, do(http.MethodPost, "/mfa/totp/enroll", session.Token, "").StatusCode)
+		assert.Equal(t, map[string]string{"code": "required"}, invalidFields(do(http.MethodPost, "/mfa/totp/confirm", session.Token, `{}`)))
+	})
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := map[string]string{
+		"report.pdf":               "report.pdf",
+		"  notes.txt ":             "notes.txt",
+		"../../etc/passwd":         "passwd",
+		`C:\Users\alice\photo.jpg`: "photo.jpg",
+		"bad\x00name\n.txt":        "badname.txt",
+		"dir/":                     "dir",
+		"..":                       "",
+		"":                         "",
+		strings.Repeat("é", 300):   strings.Repeat("é", validation.MaxFilenameLength),
+	}
+	for in, want := range tests {
+		assert.Equal(t, want, validation.SanitizeFilename(in), "SanitizeFilename(%q)", in)
+	}
+}
@@ -0,0 +1,113 @@
+package tokens
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Purposes of action tokens. A token issued for one purpose is rejected for
+// every other, and access tokens are never accepted as action tokens.
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposeResetPassword = "reset_password"
+	PurposeMFALogin      = "mfa_login"
+	PurposeChangeEmail   = "change_email"
+)
+
+// ErrTokenUsed is returned when a one-time token is presented again.
+var ErrTokenUsed = errors.New("tokens: token already used")
+
+// ActionClaims are the claims of a one-time action token.
+type ActionClaims struct {
+	UserID    string
+	TokenID   string
+	ExpiresAt time.Time
+	binding   string
+}
+
+type actionJWTClaims struct {
+	jwt.RegisteredClaims
+	Binding string `json:"bnd"`
+}
+
+// IssueActionToken signs a one-time token letting userID perform purpose. The
+// token is bound to a hash of binding, e.g. the email address being verified
+// or the current password hash, so it stops working once that changes.
+func (m *Manager) IssueActionToken(purpose, userID, binding string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	key := m.keys.signer()
+	token := jwt.NewWithClaims(key.method, actionJWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.issuer,
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings{m.actionAudience(purpose)},
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        uuid.New().String(),
+		},
+		Binding: hashBinding(binding),
+	})
+	token.Header["kid"] = key.id
+	return token.SignedString(key.private)
+}
+
+// ParseActionToken verifies a token issued for purpose. It does not use the
+// token up; call Consume once the action is allowed to go ahead.
+func (m *Manager) ParseActionToken(purpose, tokenString string) (*ActionClaims, error) {
+	parsed := &actionJWTClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, parsed, m.keyFunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(m.issuer),
+		jwt.WithAudience(m.actionAudience(purpose)),
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || parsed.Subject == "" || parsed.ID == "" {
+		return nil, ErrInvalidToken
+	}
+
+	return &ActionClaims{
+		UserID:    parsed.Subject,
+		TokenID:   parsed.ID,
+		ExpiresAt: parsed.ExpiresAt.Time,
+		binding:   parsed.Binding,
+	}, nil
+}
+
+// Bound reports whether the token was issued for this binding value.
+func (c *ActionClaims) Bound(binding string) bool {
+	return subtle.ConstantTimeCompare([]byte(c.binding), []byte(hashBinding(binding))) == 1
+}
+
+// Consume marks the token as used. It returns ErrTokenUsed if it already was.
+func (m *Manager) Consume(ctx context.Context, claims *ActionClaims) error {
+	ttl := time.Until(claims.ExpiresAt)
+	if ttl <= 0 {
+		return ErrInvalidToken
+	}
+	fresh, err := m.cache.SetNX(ctx, "auth:used:"+claims.TokenID, "1", ttl)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrTokenUsed
+	}
+	return nil
+}
+
+func (m *Manager) actionAudience(purpose string) string {
+	return fmt.Sprintf("%s:%s", m.audience, purpose)
+}
+
+func hashBinding(binding string) string {
+	sum := sha256.Sum256([]byte(binding))
+	return hex.EncodeToString(sum[:16])
+}
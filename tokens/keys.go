@@ -0,0 +1,246 @@
+package tokens
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWKSMaxAge is how long consumers may cache the published key set. A new key
+// is only used for signing once it has been published for this long, so that
+// nobody receives a token signed with a key they have not fetched yet.
+const JWKSMaxAge = 5 * time.Minute
+
+// signingKey is a key tokens are signed with or verified against. Keys loaded
+// from a PUBLIC KEY file can only verify.
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+	// published is when the key file was written, which is when the key first
+	// appeared in the JWKS.
+	published time.Time
+}
+
+// KeySet holds the keys access tokens are signed with. The key with the
+// highest ID that has been published for JWKSMaxAge signs new tokens; newer
+// keys are only published, and older ones only verify tokens issued before
+// they were rotated out.
+type KeySet struct {
+	dir string
+
+	mu      sync.RWMutex
+	keys    map[string]*signingKey
+	current *signingKey
+}
+
+// LoadKeySet reads every *.pem file in dir. Each file holds one PKCS#8 (or
+// PKCS#1 RSA) private key, or a public key for retired keys whose private half
+// has been destroyed. The file name without its extension is the key ID, so
+// naming keys by date ("2024-10-01.pem") makes the newest one sign once it has
+// been published for JWKSMaxAge.
+func LoadKeySet(dir string) (*KeySet, error) {
+	ks := &KeySet{dir: dir}
+	if err := ks.Reload(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// NewEphemeralKeySet generates an Ed25519 key that only lives as long as the
+// process, for development and tests. Tokens stop verifying on restart.
+func NewEphemeralKeySet() (*KeySet, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	key := &signingKey{method: jwt.SigningMethodEdDSA, private: private, public: public}
+	key.id = thumbprint(public)
+	return &KeySet{keys: map[string]*signingKey{key.id: key}, current: key}, nil
+}
+
+// Reload rereads the key directory, picking up added and removed keys. The
+// key set is left unchanged if the directory cannot be read.
+func (ks *KeySet) Reload() error {
+	if ks.dir == "" {
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(ks.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	keys := map[string]*signingKey{}
+	var ids, published []string
+	cutoff := time.Now().Add(-JWKSMaxAge)
+	for _, path := range paths {
+		key, err := loadKey(path)
+		if err != nil {
+			return fmt.Errorf("tokens: loading %s: %w", path, err)
+		}
+		keys[key.id] = key
+		if key.private != nil {
+			ids = append(ids, key.id)
+			if !key.published.After(cutoff) {
+				published = append(published, key.id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("tokens: no private keys in %s", ks.dir)
+	}
+	sort.Strings(ids)
+	sort.Strings(published)
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = keys
+	switch {
+	case len(published) > 0:
+		ks.current = keys[published[len(published)-1]]
+	case ks.current != nil && keys[ks.current.id] != nil && keys[ks.current.id].private != nil:
+		// Keep signing with the current key until a newer one has been
+		// published for long enough
+		ks.current = keys[ks.current.id]
+	default:
+		// On a fresh install no key has been published yet, so the newest
+		// one has to sign straight away
+		ks.current = keys[ids[len(ids)-1]]
+	}
+	return nil
+}
+
+// ReloadEvery reloads the key directory on every tick, so keys can be rotated
+// without a restart.
+func (ks *KeySet) ReloadEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := ks.Reload(); err != nil {
+			log.Println("Failed to reload signing keys:", err)
+		}
+	}
+}
+
+func loadKey(path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+
+	key := &signingKey{id: strings.TrimSuffix(filepath.Base(path), ".pem"), published: info.ModTime()}
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodRS256, k, k.Public()
+	case ed25519.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodEdDSA, k, k.Public()
+	case *rsa.PublicKey:
+		key.method, key.public = jwt.SigningMethodRS256, k
+	case ed25519.PublicKey:
+		key.method, key.public = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	if k, ok := key.public.(*rsa.PublicKey); ok && k.N.BitLen() < 2048 {
+		return nil, errors.New("RSA keys must be at least 2048 bits")
+	}
+	return key, nil
+}
+
+func (ks *KeySet) signer() *signingKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.current
+}
+
+func (ks *KeySet) lookup(id string) (*signingKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	key, ok := ks.keys[id]
+	return key, ok
+}
+
+// JWK is a public key in JSON Web Key format.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS returns the public halves of every key in the set, sorted by ID.
+func (ks *KeySet) JWKS() []JWK {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	jwks := make([]JWK, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		jwk := JWK{KeyID: key.id, Use: "sig", Algorithm: key.method.Alg()}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		jwks = append(jwks, jwk)
+	}
+	sort.Slice(jwks, func(i, j int) bool { return jwks[i].KeyID < jwks[j].KeyID })
+	return jwks
+}
+
+func thumbprint(public ed25519.PublicKey) string {
+	sum := sha256.Sum256(public)
+	return base64.RawURLEncoding.EncodeToString(sum[:8])
+}
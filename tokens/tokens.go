@@ -0,0 +1,200 @@
+// Package tokens issues and verifies the access tokens handed out at login,
+// and keeps the denylist used to revoke them before they expire.
+package tokens
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+	"trademarkia/cache"
+	"trademarkia/config"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInvalidToken is returned for tokens that are malformed, expired or
+	// not signed by us.
+	ErrInvalidToken = errors.New("tokens: invalid token")
+	// ErrRevoked is returned for valid tokens that have been revoked.
+	ErrRevoked = errors.New("tokens: token revoked")
+)
+
+// Claims are the claims carried by an access token.
+type Claims struct {
+	UserID string
+	// TokenID is the jti claim, unique to every access token
+	TokenID string
+	// FamilyID is the sid claim. Every token refreshed from the same login
+	// shares it, so a whole session can be revoked at once.
+	FamilyID string
+	// Role is the user's role when the token was issued
+	Role      string
+	ExpiresAt time.Time
+}
+
+// Manager signs access tokens and checks them against the denylist.
+type Manager struct {
+	cache     cache.Cache
+	keys      *KeySet
+	issuer    string
+	audience  string
+	accessTTL time.Duration
+}
+
+func NewManager(c cache.Cache, keys *KeySet) *Manager {
+	return &Manager{
+		cache:     c,
+		keys:      keys,
+		issuer:    config.JWT_ISSUER,
+		audience:  config.JWT_AUDIENCE,
+		accessTTL: config.ACCESS_TOKEN_TTL,
+	}
+}
+
+// AccessTTL is how long newly issued access tokens are valid for.
+func (m *Manager) AccessTTL() time.Duration {
+	return m.accessTTL
+}
+
+// Keys is the key set tokens are signed with.
+func (m *Manager) Keys() *KeySet {
+	return m.keys
+}
+
+type jwtClaims struct {
+	jwt.RegisteredClaims
+	SessionID string `json:"sid"`
+	Role      string `json:"role,omitempty"`
+}
+
+// IssueAccessToken signs a new access token for userID in the given family.
+func (m *Manager) IssueAccessToken(userID, familyID, role string) (string, *Claims, error) {
+	now := time.Now()
+	claims := &Claims{
+		UserID:    userID,
+		TokenID:   uuid.New().String(),
+		FamilyID:  familyID,
+		Role:      role,
+		ExpiresAt: now.Add(m.accessTTL),
+	}
+
+	key := m.keys.signer()
+	token := jwt.NewWithClaims(key.method, jwtClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.issuer,
+			Subject:   claims.UserID,
+			Audience:  jwt.ClaimStrings{m.audience},
+			ExpiresAt: jwt.NewNumericDate(claims.ExpiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        claims.TokenID,
+		},
+		SessionID: claims.FamilyID,
+		Role:      claims.Role,
+	})
+	token.Header["kid"] = key.id
+
+	signed, err := token.SignedString(key.private)
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
+}
+
+// ParseAccessToken verifies tokenString and makes sure neither the token nor
+// its family has been revoked.
+func (m *Manager) ParseAccessToken(ctx context.Context, tokenString string) (*Claims, error) {
+	parsed := &jwtClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, parsed, m.keyFunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(m.issuer),
+		jwt.WithAudience(m.audience),
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	claims := &Claims{
+		UserID:    parsed.Subject,
+		TokenID:   parsed.ID,
+		FamilyID:  parsed.SessionID,
+		Role:      parsed.Role,
+		ExpiresAt: parsed.ExpiresAt.Time,
+	}
+	if claims.UserID == "" || claims.TokenID == "" || claims.FamilyID == "" {
+		return nil, ErrInvalidToken
+	}
+
+	for _, key := range []string{denyTokenKey(claims.TokenID), denyFamilyKey(claims.FamilyID)} {
+		_, err := m.cache.Get(ctx, key)
+		if err == nil {
+			return nil, ErrRevoked
+		}
+		if err != cache.ErrMiss {
+			return nil, err
+		}
+	}
+	return claims, nil
+}
+
+// keyFunc finds the key a token was signed with from its kid header.
+func (m *Manager) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := m.keys.lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	// The algorithm must be the one the key was made for
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.public, nil
+}
+
+// Revoke denies a single access token until it expires.
+func (m *Manager) Revoke(ctx context.Context, claims *Claims) error {
+	ttl := time.Until(claims.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return m.cache.Set(ctx, denyTokenKey(claims.TokenID), "1", ttl)
+}
+
+// RevokeFamily denies every access token issued in the family. Tokens live
+// for at most AccessTTL, so the entry can expire after that; the refresh
+// tokens of the family have to be revoked in the database so no new ones are
+// issued.
+func (m *Manager) RevokeFamily(ctx context.Context, familyID string) error {
+	return m.cache.Set(ctx, denyFamilyKey(familyID), "1", m.accessTTL)
+}
+
+func denyTokenKey(tokenID string) string {
+	return "auth:deny:jti:" + tokenID
+}
+
+func denyFamilyKey(familyID string) string {
+	return "auth:deny:sid:" + familyID
+}
+
+// NewOpaqueToken returns a random URL-safe token with 256 bits of entropy.
+func NewOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashOpaqueToken is the form opaque tokens are stored in.
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
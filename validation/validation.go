@@ -0,0 +1,208 @@
+// Package validation checks request bodies against the validate tags on the
+// models and reports every invalid field at once, so clients can show each
+// problem next to the field it belongs to.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+	"trademarkia/apperr"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	// MaxFilenameLength is the longest filename that is stored, in characters
+	MaxFilenameLength = 255
+	// bcrypt ignores everything past the first 72 bytes of a password
+	maxPasswordBytes = 72
+)
+
+// FieldError describes one invalid field of a request body. Field is the
+// field's JSON name and Rule the rule it broke.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+var validate = newValidator()
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	// Report fields by the names clients send them as
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	v.RegisterValidation("username", func(fl validator.FieldLevel) bool {
+		return usernamePattern.MatchString(fl.Field().String())
+	})
+	v.RegisterValidation("password", func(fl validator.FieldLevel) bool {
+		return strongPassword(fl.Field().String())
+	})
+	v.RegisterValidation("bcrypt", func(fl validator.FieldLevel) bool {
+		return len(fl.Field().String()) <= maxPasswordBytes
+	})
+	v.RegisterValidation("notblank", func(fl validator.FieldLevel) bool {
+		return strings.TrimSpace(fl.Field().String()) != ""
+	})
+	v.RegisterValidation("filename", func(fl validator.FieldLevel) bool {
+		return validFilename(fl.Field().String())
+	})
+	// Unlike the built-in rule, an empty timezone is allowed: it clears the
+	// setting
+	v.RegisterValidation("timezone", func(fl validator.FieldLevel) bool {
+		name := fl.Field().String()
+		if name == "" {
+			return true
+		}
+		_, err := time.LoadLocation(name)
+		return err == nil && name != "Local"
+	})
+	return v
+}
+
+// strongPassword is the password policy: at least one letter and one
+// character that is not a letter, and short enough for bcrypt. The minimum
+// length is set on each field with min.
+func strongPassword(password string) bool {
+	if len(password) > maxPasswordBytes {
+		return false
+	}
+	var letter, other bool
+	for _, r := range password {
+		if unicode.IsLetter(r) {
+			letter = true
+		} else {
+			other = true
+		}
+	}
+	return letter && other
+}
+
+func validFilename(name string) bool {
+	if name == "." || name == ".." {
+		return false
+	}
+	for _, r := range name {
+		if r == '/' || r == '\\' || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Struct validates v, a pointer to a request body, and returns a validation
+// error listing every invalid field under the "fields" detail.
+func Struct(v interface{}) error {
+	err := validate.Struct(v)
+	if err == nil {
+		return nil
+	}
+	var invalid validator.ValidationErrors
+	if !errors.As(err, &invalid) {
+		return apperr.Internal("Failed to validate request", err)
+	}
+
+	fields := make([]FieldError, 0, len(invalid))
+	for _, fieldErr := range invalid {
+		fields = append(fields, FieldError{
+			Field:   fieldErr.Field(),
+			Rule:    fieldErr.Tag(),
+			Message: message(fieldErr),
+		})
+	}
+
+	e := apperr.Validation(fields[0].Message)
+	if len(fields) > 1 {
+		e = apperr.Validation("Some fields are invalid")
+	}
+	e.Details = map[string]interface{}{"fields": fields}
+	return e
+}
+
+func message(fieldErr validator.FieldError) string {
+	name := fieldErr.Field()
+	switch fieldErr.Tag() {
+	case "required":
+		return name + " is required"
+	case "email":
+		return name + " must be a valid email address"
+	case "min", "max":
+		bound := "at least"
+		if fieldErr.Tag() == "max" {
+			bound = "at most"
+		}
+		switch fieldErr.Kind() {
+		case reflect.String:
+			return fmt.Sprintf("%s must be %s %s characters", name, bound, fieldErr.Param())
+		case reflect.Slice, reflect.Map:
+			return fmt.Sprintf("%s must have %s %s items", name, bound, fieldErr.Param())
+		default:
+			return fmt.Sprintf("%s must be %s %s", name, bound, fieldErr.Param())
+		}
+	case "gt":
+		if fieldErr.Param() == "" {
+			return name + " must be in the future"
+		}
+		return fmt.Sprintf("%s must be greater than %s", name, fieldErr.Param())
+	case "oneof":
+		return fmt.Sprintf("%s must be one of: %s", name, strings.ReplaceAll(fieldErr.Param(), " ", ", "))
+	case "notblank":
+		return name + " must not be blank"
+	case "bcrypt":
+		return fmt.Sprintf("%s must be at most %d bytes", name, maxPasswordBytes)
+	case "username":
+		return name + " may only contain letters, digits, dots, underscores and hyphens"
+	case "password":
+		return fmt.Sprintf("%s must contain a letter and a digit or symbol, and be at most %d bytes", name, maxPasswordBytes)
+	case "filename":
+		return name + " must not contain slashes or control characters"
+	case "timezone":
+		return name + " must be a timezone name such as Europe/Berlin"
+	default:
+		return name + " is invalid"
+	}
+}
+
+// NormalizeEmail is the form email addresses are stored, looked up and rate
+// limited in: trimmed and lowercased, so the same mailbox typed differently
+// is one account.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// SanitizeFilename makes an uploaded file's name safe to store: it drops any
+// directory the client sent along, control characters and surrounding spaces,
+// and shortens it to MaxFilenameLength characters.
+func SanitizeFilename(name string) string {
+	name = strings.ReplaceAll(name, `\`, "/")
+	name = path.Base(name)
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." || name == "/" {
+		return ""
+	}
+	if utf8.RuneCountInString(name) > MaxFilenameLength {
+		name = string([]rune(name)[:MaxFilenameLength])
+	}
+	return name
+}